@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/1001R/s3rmdir/rmdir"
+)
+
+// readCheckpoint reads the checkpoint written by writeCheckpoint.
+func readCheckpoint(path string) (*rmdir.Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp rmdir.Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// writeCheckpoint replaces the file at path with cp. The file is written
+// under a temporary name first, so a crash never leaves a partial checkpoint.
+func writeCheckpoint(path string, cp rmdir.Checkpoint) {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		slog.Error("failed to encode checkpoint", "error", err)
+		return
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		slog.Error("failed to write checkpoint", "error", err)
+		return
+	}
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		slog.Error("failed to write checkpoint", "error", err)
+	}
+}
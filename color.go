@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ANSI escape sequences of the summary colors.
+const (
+	colorReset = "\x1b[0m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorBold  = "\x1b[1m"
+)
+
+// useColor is set if the summary is to be colored, see -color.
+var useColor bool
+
+// colorEnabled resolves the value of -color. Like other tools, auto colors
+// only a terminal and honors $NO_COLOR.
+func colorEnabled(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		_, noColor := os.LookupEnv("NO_COLOR")
+		return !noColor && isTerminal(os.Stdout), nil
+	}
+	return false, fmt.Errorf("illegal -color %q, expected auto, always or never", mode)
+}
+
+// colorize wraps s in the escape sequence color if useColor is set.
+func colorize(color, s string) string {
+	if !useColor {
+		return s
+	}
+	return color + s + colorReset
+}
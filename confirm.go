@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/1001R/s3rmdir/rmdir"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/term"
+)
+
+// isTerminal reports whether f is connected to a terminal.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// confirm counts the object versions selected by opts and asks the user to
+// type the bucket name to proceed. It reports whether the user agreed. There
+// is nothing to confirm if no object versions are selected, unless the
+// bucket itself is to be deleted with deleteBucket.
+func confirm(ctx context.Context, client *s3.Client, opts rmdir.Options, deleteBucket bool) (bool, error) {
+	n, err := rmdir.CountVersions(ctx, client, opts)
+	if err != nil {
+		return false, err
+	}
+	if n == 0 && !deleteBucket {
+		return true, nil
+	}
+	prefixes := strings.Join(opts.Prefixes, ", ")
+	if len(opts.Prefixes) == 0 || slices.Contains(opts.Prefixes, "") {
+		prefixes = "(entire bucket)"
+	}
+	what := fmt.Sprintf("%d object versions", n)
+	if deleteBucket {
+		what += " and then the bucket itself"
+	}
+	fmt.Fprintf(os.Stderr, "about to delete %s\n  bucket: %s\n  prefix: %s\n", what, opts.Bucket, prefixes)
+	return askBucketName(opts.Bucket), nil
+}
+
+// confirmTargets lists the targets matched by -bucket-pattern and asks the
+// user to type the pattern to proceed. It reports whether the user agreed.
+func confirmTargets(targets []target, pattern string) bool {
+	fmt.Fprintf(os.Stderr, "about to delete from %d targets:\n", len(targets))
+	for _, t := range targets {
+		prefix := t.Prefix
+		if prefix == "" {
+			prefix = "(entire bucket)"
+		}
+		fmt.Fprintf(os.Stderr, "  %s %s\n", t.Bucket, prefix)
+	}
+	fmt.Fprint(os.Stderr, "type the bucket pattern to proceed: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	return strings.TrimSpace(answer) == pattern
+}
+
+// askBucketName asks the user to type the bucket name and reports whether
+// the answer matches.
+func askBucketName(bucket string) bool {
+	fmt.Fprint(os.Stderr, "type the bucket name to proceed: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	return strings.TrimSpace(answer) == bucket
+}
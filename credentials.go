@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// staticCredentials returns a provider of the given access key, which takes
+// the place of the default credential chain.
+func staticCredentials(accessKey, secretKey, sessionToken string) aws.CredentialsProvider {
+	return aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(accessKey, secretKey, sessionToken))
+}
+
+// assumeRole replaces the credentials of cfg with those of the role, which
+// are obtained with the original credentials. It assumes the role once to
+// fail early if that is denied.
+func assumeRole(ctx context.Context, cfg *aws.Config, roleARN, sessionName string) error {
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(*cfg), roleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = sessionName
+	})
+	cfg.Credentials = aws.NewCredentialsCache(provider)
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/1001R/s3rmdir/rmdir"
+)
+
+// planKey identifies an object version across a plan and a listing. The
+// modification time tells apart the objects of an unversioned bucket that
+// have been overwritten since the plan was made.
+type planKey struct {
+	key          string
+	versionId    string
+	lastModified int64
+}
+
+type diffRecord struct {
+	Status    string `json:"status"`
+	Key       string `json:"key"`
+	VersionId string `json:"version_id,omitempty"`
+}
+
+type diffTotals struct {
+	New      int `json:"new"`
+	Gone     int `json:"gone"`
+	Matching int `json:"matching"`
+}
+
+// diffPlan lists the object versions that opts would delete in a dry run and
+// compares them with the plan at path. It writes the object versions that
+// are new since the plan was made and those of the plan that are no longer
+// selected, followed by the totals.
+func diffPlan(ctx context.Context, client rmdir.S3API, opts rmdir.Options, path string) error {
+	f, plan := openPlan(path, opts.Bucket)
+	defer f.Close()
+	planned := make(map[planKey]bool)
+	for {
+		e, err := plan.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		planned[planKey{e.Key, e.VersionId, e.LastModified.UnixNano()}] = true
+	}
+
+	var added []rmdir.ObjectVersion
+	var totals diffTotals
+	opts.DryRun = true
+	opts.OnBatch = func(r rmdir.BatchResult) {
+		for _, v := range r.DryRun {
+			k := planKey{v.Key, v.VersionId, v.LastModified.UnixNano()}
+			if planned[k] {
+				delete(planned, k)
+				totals.Matching++
+			} else {
+				added = append(added, v)
+			}
+		}
+	}
+	if _, err := rmdir.DeleteVersions(ctx, client, opts); err != nil {
+		return err
+	}
+	gone := make([]planKey, 0, len(planned))
+	for k := range planned {
+		gone = append(gone, k)
+	}
+	sort.Slice(gone, func(i, j int) bool {
+		if gone[i].key != gone[j].key {
+			return gone[i].key < gone[j].key
+		}
+		return gone[i].lastModified > gone[j].lastModified
+	})
+	totals.New = len(added)
+	totals.Gone = len(gone)
+
+	if opts.Format == rmdir.FormatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, v := range added {
+			encoder.Encode(diffRecord{Status: "new", Key: v.Key, VersionId: v.VersionId})
+		}
+		for _, k := range gone {
+			encoder.Encode(diffRecord{Status: "gone", Key: k.key, VersionId: k.versionId})
+		}
+		return encoder.Encode(totals)
+	}
+	for _, v := range added {
+		fmt.Printf("+ %s\n", describeVersion(v.Key, v.VersionId, v.LastModified))
+	}
+	for _, k := range gone {
+		fmt.Printf("- %s\n", describeVersion(k.key, k.versionId, time.Unix(0, k.lastModified)))
+	}
+	fmt.Printf("%d new, %d gone, %d matching the plan\n", totals.New, totals.Gone, totals.Matching)
+	return nil
+}
+
+func describeVersion(key, versionId string, lastModified time.Time) string {
+	if versionId == "" {
+		return fmt.Sprintf("%s (modified %s)", rmdir.QuoteKey(key), lastModified.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%s (version %s)", rmdir.QuoteKey(key), versionId)
+}
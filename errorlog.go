@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/1001R/s3rmdir/rmdir"
+)
+
+var errorLogHeader = []string{"key", "version_id", "code", "message"}
+
+// writeErrorLog writes failures as CSV to the file at path.
+func writeErrorLog(path string, failures []rmdir.Failure) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write(errorLogHeader)
+	for _, failure := range failures {
+		w.Write([]string{failure.Key, failure.VersionId, failure.Code, failure.Message})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readErrorLog reads the failures written by writeErrorLog to the file at
+// path.
+func readErrorLog(path string) ([]rmdir.Failure, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error log header: %w", err)
+	}
+	if !slices.Equal(header, errorLogHeader) {
+		return nil, fmt.Errorf("not an error log, expected the header %s", strings.Join(errorLogHeader, ","))
+	}
+	var failures []rmdir.Failure
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return failures, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record[0] == "" {
+			line, _ := r.FieldPos(0)
+			return nil, fmt.Errorf("error log line %d: empty key", line)
+		}
+		failures = append(failures, rmdir.Failure{Key: record[0], VersionId: record[1], Code: record[2], Message: record[3]})
+	}
+}
+
+// failureInput returns the object versions of failures as input for
+// Options.Input.
+func failureInput(failures []rmdir.Failure) []byte {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	for _, failure := range failures {
+		w.Write([]string{failure.Key, failure.VersionId})
+	}
+	w.Flush()
+	return b.Bytes()
+}
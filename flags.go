@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// stringList is a flag.Value collecting the values of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// hiddenFlags are left out of the usage message. They are testing aids
+// rather than options of a regular run.
+var hiddenFlags = map[string]bool{"simulate-error-rate": true}
+
+// usage writes the usage message of the flags that are not hidden.
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+	visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	visible.SetOutput(flag.CommandLine.Output())
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+			visible.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	visible.PrintDefaults()
+}
+
+// isFlagSet reports whether the flag of the given name was given on the
+// command line, as opposed to left at its default.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// folderPrefix turns p into the prefix of the keys in folder p.
+func folderPrefix(p string) string {
+	p = strings.Trim(p, "/")
+	if p != "" {
+		p += "/"
+	}
+	return p
+}
+
+// hexShards splits a keyspace of hexadecimal keys into 16 ranges, one per
+// leading digit. A range ends with its boundary, so the boundaries are the
+// digits that start the next range.
+var hexShards = strings.Split("1,2,3,4,5,6,7,8,9,a,b,c,d,e,f", ",")
+
+// parseShards parses a comma-separated list of shard boundaries. The value
+// hex selects boundaries for keys starting with a hexadecimal digit.
+func parseShards(s string) []string {
+	switch s {
+	case "":
+		return nil
+	case "hex":
+		return hexShards
+	}
+	return strings.Split(s, ",")
+}
+
+// parseTime parses s as an RFC 3339 timestamp or as a duration before now.
+// An empty string yields the zero time.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("neither an RFC 3339 timestamp nor a duration: %s", s)
+	}
+	return now.Add(-d), nil
+}
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"TB", 1e12},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"T", 1 << 40},
+	{"B", 1},
+}
+
+// parseSize parses a byte count with an optional unit like 10MB or 1.5GiB.
+// An empty string yields zero.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	factor := int64(1)
+	number := s
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(u.suffix)) {
+			factor = u.factor
+			number = strings.TrimSpace(s[:len(s)-len(u.suffix)])
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 || n*float64(factor) > math.MaxInt64 {
+		return 0, fmt.Errorf("not a valid size: %s", s)
+	}
+	return int64(n * float64(factor)), nil
+}
+
+// isBucketARN reports whether bucket is an access point or Outposts ARN
+// rather than a bucket name. It fails for other ARNs and for names that
+// cannot be bucket names.
+func isBucketARN(bucket string) (bool, error) {
+	if !arn.IsARN(bucket) {
+		if strings.ContainsAny(bucket, "/: ") {
+			return false, fmt.Errorf("not a valid bucket name: %s", bucket)
+		}
+		return false, nil
+	}
+	a, err := arn.Parse(bucket)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case a.Service == "s3" && strings.HasPrefix(a.Resource, "accesspoint"):
+	case a.Service == "s3-outposts" && strings.HasPrefix(a.Resource, "outpost"):
+	default:
+		return false, fmt.Errorf("not an access point or Outposts ARN: %s", bucket)
+	}
+	if a.Region == "" {
+		return false, fmt.Errorf("ARN without region: %s", bucket)
+	}
+	return true, nil
+}
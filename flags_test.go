@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestHexShards checks that the keys of every leading hex digit fall into a
+// range of their own under the rule of rmdir.Options.Shards, by which a
+// range holds the keys after the previous boundary up to its own.
+func TestHexShards(t *testing.T) {
+	shards := parseShards("hex")
+	if len(shards) != 15 {
+		t.Fatalf("%d boundaries, want 15", len(shards))
+	}
+	rangeOf := func(key string) int {
+		for i, boundary := range shards {
+			if key <= boundary {
+				return i
+			}
+		}
+		return len(shards)
+	}
+	for i, digit := range "0123456789abcdef" {
+		for _, key := range []string{string(digit) + "0", fmt.Sprintf("%c%040x", digit, 0), string(digit) + "fff/object"} {
+			if r := rangeOf(key); r != i {
+				t.Errorf("key %s in range %d, want %d", key, r, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// httpOptions configures the HTTP client of the SDK.
+type httpOptions struct {
+	// InsecureSkipVerify disables the verification of the server
+	// certificate.
+	InsecureSkipVerify bool
+	// Timeout limits the duration of a request including reading the
+	// response, unless it is zero.
+	Timeout time.Duration
+	// MaxIdleConns is the maximum number of idle connections kept open.
+	MaxIdleConns int
+	// MaxConnsPerHost limits the number of connections to the endpoint,
+	// unless it is zero.
+	MaxConnsPerHost int
+	// Concurrency is the number of concurrent delete requests; as many idle
+	// connections are kept for the endpoint so that they are reused.
+	Concurrency int
+}
+
+// newHTTPClient returns the HTTP client of the SDK.
+func newHTTPClient(o httpOptions) *awshttp.BuildableClient {
+	return awshttp.NewBuildableClient().
+		WithTimeout(o.Timeout).
+		WithTransportOptions(func(t *http.Transport) {
+			t.MaxIdleConns = o.MaxIdleConns
+			t.MaxIdleConnsPerHost = max(t.MaxIdleConnsPerHost, o.Concurrency)
+			t.MaxConnsPerHost = o.MaxConnsPerHost
+			if o.InsecureSkipVerify {
+				if t.TLSClientConfig == nil {
+					t.TLSClientConfig = &tls.Config{}
+				}
+				t.TLSClientConfig.InsecureSkipVerify = true
+			}
+		})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// newLogger returns a logger writing to stderr at the given level (debug,
+// info, warn or error) in the given format (text or json).
+func newLogger(level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("illegal log level: %s", level)
+	}
+	handlerOptions := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, handlerOptions)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, handlerOptions)), nil
+	}
+	return nil, fmt.Errorf("illegal log format: %s", format)
+}
+
+// fatal logs msg at error level and exits with code 1.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
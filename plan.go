@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/1001R/s3rmdir/rmdir"
+)
+
+// openPlan opens the plan written by -plan-file and verifies that it is for
+// bucket. The caller closes the returned file after the run.
+func openPlan(path, bucket string) (*os.File, *rmdir.PlanReader) {
+	f, err := os.Open(path)
+	if err != nil {
+		fatal("failed to open plan", "error", err)
+	}
+	plan, err := rmdir.ReadPlan(bufio.NewReader(f))
+	if err != nil {
+		fatal("failed to read plan", "error", err)
+	}
+	if plan.Bucket != bucket {
+		fatal("plan is for a different bucket", "bucket", plan.Bucket)
+	}
+	return f, plan
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// checkBucket verifies with HeadBucket that bucket exists and is accessible
+// in the region of client. On failure it returns an actionable message and
+// the exit code, which is 1 for unexpected errors.
+func checkBucket(ctx context.Context, client *s3.Client, bucket string) (string, int) {
+	_, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err == nil {
+		return "", 0
+	}
+	var re *awshttp.ResponseError
+	if !errors.As(err, &re) {
+		return fmt.Sprintf("failed to access bucket %s: %v", bucket, err), 1
+	}
+	switch re.HTTPStatusCode() {
+	case http.StatusNotFound:
+		return fmt.Sprintf("bucket %s does not exist, check the -bucket value", bucket), exitNoSuchBucket
+	case http.StatusForbidden:
+		return fmt.Sprintf("access to bucket %s is denied, check the credentials, -profile and -role-arn, and the bucket policy (s3:ListBucket is required)", bucket), exitAccessDenied
+	case http.StatusMovedPermanently, http.StatusBadRequest:
+		if region := re.Response.Header.Get("x-amz-bucket-region"); region != "" {
+			return fmt.Sprintf("bucket %s is located in %s, use -region %s or -auto-region", bucket, region, region), exitWrongRegion
+		}
+	}
+	return fmt.Sprintf("failed to access bucket %s: %v", bucket, err), 1
+}
+
+// warnVersioning logs a notice if the versioning state of bucket does not
+// match the listing mode. The check is advisory, failures are ignored.
+func warnVersioning(ctx context.Context, client *s3.Client, bucket string, noVersions bool) {
+	versioning, err := client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		slog.Debug("failed to get bucket versioning", "bucket", bucket, "error", err)
+		return
+	}
+	switch {
+	case versioning.Status == types.BucketVersioningStatusEnabled && noVersions:
+		slog.Warn("versioning is enabled, -no-versions creates delete markers instead of deleting the objects", "bucket", bucket)
+	case versioning.Status == types.BucketVersioningStatusSuspended && !noVersions:
+		slog.Warn("versioning is suspended, objects written since then only have a null version", "bucket", bucket)
+	case versioning.Status == "" && !noVersions:
+		slog.Warn("versioning has never been enabled, every object has a single null version; -no-versions lists the bucket more cheaply", "bucket", bucket)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// bucketRegion asks S3 for the region of bucket.
+func bucketRegion(ctx context.Context, client *s3.Client, bucket string) (string, error) {
+	location, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return "", err
+	}
+	switch location.LocationConstraint {
+	case "":
+		// buckets in us-east-1 have no location constraint
+		return "us-east-1", nil
+	case types.BucketLocationConstraintEu:
+		return "eu-west-1", nil
+	}
+	return string(location.LocationConstraint), nil
+}
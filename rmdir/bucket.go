@@ -0,0 +1,55 @@
+package rmdir
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// IsDirectoryBucket reports whether bucket is named like an S3 Express One
+// Zone directory bucket, e.g. "name--usw2-az1--x-s3".
+func IsDirectoryBucket(bucket string) bool {
+	return strings.HasSuffix(bucket, "--x-s3")
+}
+
+// DeleteBucket deletes opts.Bucket after verifying that it contains neither
+// object versions nor delete markers, or no objects in case of a directory
+// bucket. The check is subject to opts.RequestPayer like the run.
+func DeleteBucket(ctx context.Context, client S3API, opts Options) error {
+	empty, err := isEmpty(ctx, client, &opts)
+	if err != nil {
+		return fmt.Errorf("failed to list objects: %w", err)
+	}
+	if !empty {
+		return fmt.Errorf("bucket %s is not empty", opts.Bucket)
+	}
+	if _, err := client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(opts.Bucket)}); err != nil {
+		return fmt.Errorf("failed to delete bucket: %w", err)
+	}
+	return nil
+}
+
+func isEmpty(ctx context.Context, client S3API, opts *Options) (bool, error) {
+	if opts.DirectoryBucket || IsDirectoryBucket(opts.Bucket) {
+		page, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(opts.Bucket),
+			MaxKeys: aws.Int32(1),
+		})
+		if err != nil {
+			return false, err
+		}
+		return len(page.Contents) == 0, nil
+	}
+	page, err := client.ListObjectVersions(ctx, &s3.ListObjectVersionsInput{
+		Bucket:       aws.String(opts.Bucket),
+		RequestPayer: opts.requestPayer(),
+		MaxKeys:      aws.Int32(1),
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(page.Versions) == 0 && len(page.DeleteMarkers) == 0, nil
+}
@@ -0,0 +1,142 @@
+package rmdir
+
+import (
+	"sync"
+	"time"
+)
+
+// checkpointInterval is the minimum time between two calls of
+// Options.OnCheckpoint.
+const checkpointInterval = 5 * time.Second
+
+// Checkpoint records how far a run has progressed, so that an interrupted run
+// can be resumed with Options.Resume.
+type Checkpoint struct {
+	// Bucket the run deletes from.
+	Bucket string `json:"bucket"`
+	// Positions holds the progress of every listed key range.
+	Positions []Position `json:"positions"`
+}
+
+// Position is the progress within the keys of a prefix after After, which is
+// a shard boundary or empty.
+//
+// All batches with keys up to KeyMarker have completed. Because the versions
+// of a key may span several batches, KeyMarker is the last key whose versions
+// have all been processed rather than the marker of the last request: a
+// resumed run lists the following key again from its most recent version,
+// which keeps Options.Keep correct. Keys up to KeyMarker written after the
+// checkpoint are not deleted by the resumed run.
+type Position struct {
+	Prefix    string `json:"prefix"`
+	After     string `json:"after"`
+	KeyMarker string `json:"key_marker"`
+}
+
+// resumeMarker returns the key after which the listing of prefix after r.After
+// is to continue.
+func (opts *Options) resumeMarker(prefix string, r keyRange) string {
+	if opts.Resume == nil {
+		return r.After
+	}
+	for _, p := range opts.Resume.Positions {
+		if p.Prefix == prefix && p.After == r.After && p.KeyMarker > r.After {
+			return p.KeyMarker
+		}
+	}
+	return r.After
+}
+
+// batchPosition identifies a batch within the batches of a key range.
+type batchPosition struct {
+	// rangeIndex is the index of the key range in checkpointer.ranges.
+	rangeIndex int
+	// seq numbers the batches of the range in the order they are submitted.
+	seq int
+	// doneKey is the last key whose versions have all been submitted by
+	// this and the preceding batches of the range.
+	doneKey string
+}
+
+// checkpointer tracks the progress of the listed key ranges. As batches
+// complete out of order, the position of a range only advances over the
+// batches that have all completed.
+type checkpointer struct {
+	mu     sync.Mutex
+	bucket string
+	// resume is the checkpoint the run was resumed from, if any
+	resume *Checkpoint
+	ranges []*rangeProgress
+}
+
+type rangeProgress struct {
+	position Position
+	// next is the seq of the first batch that has not completed
+	next int
+	// done holds the doneKey of the completed batches from next on
+	done map[int]string
+	// failed is set once a batch of the range has failed as a whole, which
+	// stops the position from advancing
+	failed bool
+}
+
+// add registers a key range whose listing starts after marker and returns its
+// index.
+func (c *checkpointer) add(prefix string, r keyRange, marker string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ranges = append(c.ranges, &rangeProgress{
+		position: Position{Prefix: prefix, After: r.After, KeyMarker: marker},
+		done:     make(map[int]string),
+	})
+	return len(c.ranges) - 1
+}
+
+// complete records a completed batch and reports whether the position of its
+// range has advanced.
+func (c *checkpointer) complete(b batchPosition, ok bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.ranges[b.rangeIndex]
+	if !ok {
+		r.failed = true
+	}
+	if r.failed {
+		return false
+	}
+	r.done[b.seq] = b.doneKey
+	advanced := false
+	for {
+		key, ok := r.done[r.next]
+		if !ok {
+			return advanced
+		}
+		delete(r.done, r.next)
+		r.next++
+		if key > r.position.KeyMarker {
+			r.position.KeyMarker = key
+			advanced = true
+		}
+	}
+}
+
+// checkpoint returns the current positions, including those of the resumed
+// checkpoint whose ranges have not been listed yet.
+func (c *checkpointer) checkpoint() Checkpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cp := Checkpoint{Bucket: c.bucket, Positions: make([]Position, 0, len(c.ranges))}
+	listed := make(map[Position]bool, len(c.ranges))
+	for _, r := range c.ranges {
+		cp.Positions = append(cp.Positions, r.position)
+		listed[Position{Prefix: r.position.Prefix, After: r.position.After}] = true
+	}
+	if c.resume != nil {
+		for _, p := range c.resume.Positions {
+			if !listed[Position{Prefix: p.Prefix, After: p.After}] {
+				cp.Positions = append(cp.Positions, p)
+			}
+		}
+	}
+	return cp
+}
@@ -0,0 +1,199 @@
+package rmdir
+
+import (
+	"context"
+	"fmt"
+)
+
+// Count holds the totals of the entries selected by a run.
+type Count struct {
+	// Keys is the number of distinct keys.
+	Keys int `json:"keys"`
+	// Versions is the number of object versions including delete markers.
+	Versions int `json:"versions"`
+	// DeleteMarkers is the number of delete markers.
+	DeleteMarkers int `json:"delete_markers"`
+	// Bytes is the total size of the object versions.
+	Bytes int64 `json:"bytes"`
+	// Listed is the number of entries listed, including those rejected by
+	// the filters.
+	Listed int `json:"listed"`
+	// Sampled is set if the listing stopped at Options.SamplePages before
+	// all entries were listed.
+	Sampled bool `json:"sampled"`
+	// Estimated extrapolates the totals to all keys of the prefixes from
+	// the share of their key space the sample covers, if Sampled. It is
+	// nil if the share cannot be told, e.g. for input entries, directory
+	// buckets, resumed ranges or keys that do not differ within a few
+	// bytes.
+	Estimated *Estimate `json:"estimated,omitempty"`
+	// ListFailures describes the key ranges that could not be listed with
+	// Options.ContinueOnListError; their entries are not counted.
+	ListFailures []ListFailure `json:"list_failures,omitempty"`
+}
+
+// CountVersions lists all object versions and delete markers that
+// DeleteVersions would delete with the same options and returns their number.
+func CountVersions(ctx context.Context, client S3API, opts Options) (int, error) {
+	c, err := Tally(ctx, client, opts)
+	return c.Versions, err
+}
+
+// Tally lists all object versions and delete markers that DeleteVersions
+// would delete with the same options and returns their totals. Like
+// DeleteVersions, it skips the keys Options.Resume records as processed and
+// handles list errors as Options.ContinueOnListError tells.
+func Tally(ctx context.Context, client S3API, opts Options) (Count, error) {
+	prefixes := opts.prefixes()
+	if opts.explicit() {
+		prefixes = []string{""}
+	}
+	var c Count
+	// the totals of the prefixes divided by the share of their key space
+	// listed; ordered listings of buckets can be extrapolated
+	var estimated struct {
+		keys, versions, markers, bytes, listed float64
+	}
+	canEstimate := !opts.explicit() && !opts.DirectoryBucket
+	logger := opts.logger()
+	singleEntries := opts
+	singleEntries.MaxKeys = 1
+	for _, prefix := range prefixes {
+		ranges := []keyRange{{}}
+		if !opts.explicit() {
+			ranges = opts.keyRanges(prefix)
+		}
+		selector := newSelector(&opts)
+		countedKey := ""
+		before := c
+		var space keySpace
+		sampled := false
+		// pages counts the pages listed from prefix, a failed page listed
+		// again entry by entry counts once
+		pages := 0
+		for _, r := range ranges {
+			if sampled {
+				break
+			}
+			marker := opts.resumeMarker(prefix, r)
+			if marker != r.After {
+				// the skipped keys would count as listed
+				canEstimate = false
+			}
+			objectPager := newPager(client, &opts, prefix, keyRange{After: marker, Until: r.Until})
+			// the same handling of list errors as in DeleteVersions
+			lastKey, lastVersion, doneKey := "", "", marker
+			restart := func(o *Options) pager {
+				if lastKey == "" {
+					return newPager(client, o, prefix, keyRange{After: marker, Until: r.Until})
+				}
+				return resumePager(client, o, prefix, r, lastKey, lastVersion)
+			}
+			singlePages := 0
+			for objectPager.HasMorePages() {
+				if opts.SamplePages > 0 && pages == opts.SamplePages {
+					c.Sampled = true
+					sampled = true
+					break
+				}
+				var entries []listEntry
+				var err error
+				if opts.ContinueOnListError && !opts.explicit() {
+					entries, err = nextPageWithRetries(ctx, objectPager)
+				} else {
+					entries, err = objectPager.nextPage(ctx)
+				}
+				if err != nil {
+					if ctx.Err() != nil || !opts.ContinueOnListError || opts.explicit() {
+						return c, fmt.Errorf("failed to list objects: %w", err)
+					}
+					if singlePages == 0 {
+						logger.Warn("failed to list objects, listing the page again entry by entry", "prefix", prefix, "after", lastKey, "error", err)
+						singlePages = opts.maxKeys()
+						objectPager = restart(&singleEntries)
+						continue
+					}
+					logger.Warn("failed to list objects, skipping the rest of the range", "prefix", prefix, "after", doneKey, "error", err)
+					c.ListFailures = append(c.ListFailures, ListFailure{
+						Prefix:  prefix,
+						After:   doneKey,
+						Until:   r.Until,
+						Message: err.Error(),
+					})
+					canEstimate = false
+					break
+				}
+				c.Listed += len(entries)
+				for _, e := range entries {
+					if e.Key != lastKey {
+						if lastKey != "" {
+							doneKey = lastKey
+						}
+						lastKey = e.Key
+					}
+					lastVersion = e.VersionId
+					space.add(e.Key)
+					if selector.match(e) != "" {
+						continue
+					}
+					if opts.MaxObjects > 0 && c.Versions == opts.MaxObjects {
+						return c, nil
+					}
+					c.Versions++
+					if e.DeleteMarker {
+						c.DeleteMarkers++
+					}
+					c.Bytes += e.Size
+					if e.Key != countedKey {
+						c.Keys++
+						countedKey = e.Key
+					}
+				}
+				if singlePages > 0 {
+					singlePages--
+					if singlePages > 0 {
+						continue
+					}
+					if objectPager.HasMorePages() {
+						// past the failed page
+						objectPager = restart(&opts)
+					}
+				}
+				pages++
+			}
+		}
+		if !canEstimate {
+			continue
+		}
+		share := 1.0
+		if sampled {
+			ok := false
+			if space.first != "" {
+				end, err := largestKey(ctx, client, &opts, prefix, space.first)
+				if err != nil {
+					return c, fmt.Errorf("failed to estimate the key space: %w", err)
+				}
+				share, ok = space.share(end)
+			}
+			if !ok {
+				canEstimate = false
+				continue
+			}
+		}
+		estimated.keys += float64(c.Keys-before.Keys) / share
+		estimated.versions += float64(c.Versions-before.Versions) / share
+		estimated.markers += float64(c.DeleteMarkers-before.DeleteMarkers) / share
+		estimated.bytes += float64(c.Bytes-before.Bytes) / share
+		estimated.listed += float64(c.Listed-before.Listed) / share
+	}
+	if c.Sampled && canEstimate && estimated.listed > 0 {
+		c.Estimated = &Estimate{
+			Keys:          int(estimated.keys),
+			Versions:      int(estimated.versions),
+			DeleteMarkers: int(estimated.markers),
+			Bytes:         int64(estimated.bytes),
+			Share:         float64(c.Listed) / estimated.listed,
+		}
+	}
+	return c, nil
+}
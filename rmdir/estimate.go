@@ -0,0 +1,184 @@
+package rmdir
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Estimate holds the totals of a sampled Tally extrapolated to all keys of
+// the prefixes.
+type Estimate struct {
+	Keys          int   `json:"keys"`
+	Versions      int   `json:"versions"`
+	DeleteMarkers int   `json:"delete_markers"`
+	Bytes         int64 `json:"bytes"`
+	// Share is the estimated share of all entries that were listed.
+	Share float64 `json:"share"`
+}
+
+// positionBytes is the number of bytes after the prefix common to the keys
+// of a prefix that determine the position of a key in the key space.
+const positionBytes = 4
+
+// maxPositionBytes bounds the length of the keys considered for positions.
+const maxPositionBytes = 64
+
+// alphabets are the digits keys are commonly made of, smallest first.
+var alphabets = []string{
+	"0123456789",
+	"0123456789abcdef",
+	"0123456789ABCDEF",
+	"0123456789abcdefghijklmnopqrstuvwxyz",
+	"0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+}
+
+// keySpace tracks the keys listed from a prefix, in order, to estimate the
+// share of the key space of the prefix they cover. The positions of keys
+// are read in a mixed radix, with the smallest of the alphabets that holds
+// the bytes seen at an offset as its digits, so that e.g. decimal or
+// hexadecimal keys are spread over the whole key space instead of a few
+// values of a byte. Other bytes count from the lowest to the highest value
+// seen.
+type keySpace struct {
+	first, last string
+	seen        [maxPositionBytes][256]bool
+}
+
+func (s *keySpace) add(key string) {
+	if s.first == "" {
+		s.first = key
+	}
+	s.last = key
+	s.addDigits(key)
+}
+
+// addDigits adds the bytes of key to the bytes seen.
+func (s *keySpace) addDigits(key string) {
+	for i := 0; i < len(key) && i < maxPositionBytes; i++ {
+		s.seen[i][key[i]] = true
+	}
+}
+
+// digits returns the digits of offset i: the alphabet holding the bytes
+// seen there, or the range of their values.
+func (s *keySpace) digits(i int) string {
+	var seen []byte
+	for b, ok := range s.seen[i] {
+		if ok {
+			seen = append(seen, byte(b))
+		}
+	}
+	if len(seen) == 0 {
+		return ""
+	}
+next:
+	for _, alphabet := range alphabets {
+		for _, b := range seen {
+			if strings.IndexByte(alphabet, b) < 0 {
+				continue next
+			}
+		}
+		return alphabet
+	}
+	digits := make([]byte, 0, int(seen[len(seen)-1]-seen[0])+1)
+	for b := int(seen[0]); b <= int(seen[len(seen)-1]); b++ {
+		digits = append(digits, byte(b))
+	}
+	return string(digits)
+}
+
+// share returns the share of the key space from the first key listed up to
+// end that the keys listed so far cover. It reports false if the positions
+// of the keys cannot be told apart.
+func (s *keySpace) share(end string) (float64, bool) {
+	s.addDigits(end)
+	common := 0
+	for common < len(s.first) && common < len(end) && s.first[common] == end[common] {
+		common++
+	}
+	var digits []string
+	for i := common; i < common+positionBytes && i < maxPositionBytes; i++ {
+		digits = append(digits, s.digits(i))
+	}
+	position := func(key string) float64 {
+		p, scale := 0.0, 1.0
+		for i, d := range digits {
+			if d == "" {
+				break
+			}
+			scale /= float64(len(d))
+			if common+i < len(key) {
+				p += float64(strings.IndexByte(d, key[common+i])) * scale
+			}
+		}
+		return p
+	}
+	start := position(s.first)
+	total := position(end) - start
+	listed := position(s.last) - start
+	if total <= 0 || listed <= 0 {
+		return 0, false
+	}
+	return min(listed/total, 1), true
+}
+
+// largestKey returns the beginning of the largest key below prefix, up to
+// positionBytes bytes after it differs from first; its last byte may be one
+// too low where the largest key ends. It finds every byte in a binary search
+// of list requests for a single entry after a marker, so it costs 8 requests
+// per byte.
+func largestKey(ctx context.Context, client S3API, opts *Options, prefix, first string) (string, error) {
+	key := prefix
+	for len(key) < maxPositionBytes {
+		// the largest byte c with a key after key+c
+		c := -1
+		for lo, hi := 0, 0xff; lo <= hi; {
+			mid := (lo + hi) / 2
+			ok, err := keyAfter(ctx, client, opts, prefix, key+string([]byte{byte(mid)}))
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				c, lo = mid, mid+1
+			} else {
+				hi = mid - 1
+			}
+		}
+		if c < 0 {
+			break
+		}
+		key += string([]byte{byte(c)})
+		common := 0
+		for common < len(key) && common < len(first) && key[common] == first[common] {
+			common++
+		}
+		if common < len(key) && len(key) >= common+positionBytes {
+			break
+		}
+	}
+	return key, nil
+}
+
+// keyAfter reports whether any key below prefix follows marker.
+func keyAfter(ctx context.Context, client S3API, opts *Options, prefix, marker string) (bool, error) {
+	if opts.NoVersions {
+		params := opts.listObjectsV2Input(prefix)
+		params.StartAfter = aws.String(marker)
+		params.MaxKeys = aws.Int32(1)
+		out, err := client.ListObjectsV2(ctx, params)
+		if err != nil {
+			return false, err
+		}
+		return len(out.Contents)+len(out.CommonPrefixes) > 0, nil
+	}
+	params := opts.listObjectVersionsInput(prefix)
+	params.KeyMarker = aws.String(marker)
+	params.MaxKeys = aws.Int32(1)
+	out, err := client.ListObjectVersions(ctx, params)
+	if err != nil {
+		return false, err
+	}
+	return len(out.Versions)+len(out.DeleteMarkers)+len(out.CommonPrefixes) > 0, nil
+}
@@ -0,0 +1,148 @@
+package rmdir
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// selector applies the filters of a run to the listed entries in listing
+// order, keeping track of the position of each entry among the versions of
+// its key.
+type selector struct {
+	opts *Options
+	key  string
+	rank int
+	// newer is the time the previous, newer version of key was written,
+	// which is when the current entry became noncurrent
+	newer time.Time
+}
+
+func newSelector(opts *Options) *selector {
+	return &selector{opts: opts}
+}
+
+// match is like Options.match, but also protects the opts.Keep most recent
+// versions of every key and applies opts.NoncurrentOlderThan. The versions of
+// a key may span several pages.
+func (s *selector) match(e listEntry) string {
+	if e.Key != s.key {
+		s.key = e.Key
+		s.rank = 0
+		s.newer = time.Time{}
+	}
+	s.rank++
+	newer := s.newer
+	s.newer = e.LastModified
+	if s.rank <= s.opts.Keep {
+		return SkippedByKeep
+	}
+	if s.opts.LatestOnly && (!e.IsLatest || e.DeleteMarker) {
+		return SkippedByLatest
+	}
+	if !s.opts.NoncurrentOlderThan.IsZero() &&
+		(e.IsLatest || newer.IsZero() || !newer.Before(s.opts.NoncurrentOlderThan)) {
+		return SkippedByNoncurrent
+	}
+	return s.opts.match(e)
+}
+
+// Names of the filters reported in Summary.Skipped.
+const (
+	SkippedByDate    = "date"
+	SkippedBySize    = "size"
+	SkippedBySuffix  = "suffix"
+	SkippedByPattern = "pattern"
+	SkippedByKeep    = "keep"
+	SkippedByLock    = "lock"
+	SkippedByClass   = "storage class"
+	SkippedByTag     = "tag"
+	SkippedByOwner   = "owner"
+	SkippedByMarker  = "delete marker"
+	// SkippedByFolder counts the versions that are not folder placeholders
+	// with Options.EmptyFolders.
+	SkippedByFolder = "empty folders"
+	// SkippedByStart counts the versions written after
+	// Options.WrittenBefore.
+	SkippedByStart = "written during run"
+	// SkippedByExcludedPrefix counts the versions protected by
+	// Options.ExcludePrefixes.
+	SkippedByExcludedPrefix = "excluded prefix"
+	// SkippedByPrefixAnomaly counts the keys listed outside of the
+	// requested prefix, see Options.Strict.
+	SkippedByPrefixAnomaly = "outside prefix"
+	// SkippedByAge counts the versions younger than Options.MinAge.
+	SkippedByAge = "min age"
+	// SkippedByLatest counts the noncurrent versions and the current delete
+	// markers left by Options.LatestOnly.
+	SkippedByLatest = "latest only"
+	// SkippedByNoncurrent counts the current versions and the versions that
+	// became noncurrent after Options.NoncurrentOlderThan.
+	SkippedByNoncurrent = "noncurrent"
+)
+
+// match checks e against the filters of opts. It returns the name of the
+// filter that rejected e, or the empty string if e is to be deleted.
+func (opts *Options) match(e listEntry) string {
+	if !opts.WrittenBefore.IsZero() && e.LastModified.After(opts.WrittenBefore) {
+		return SkippedByStart
+	}
+	if opts.MinAge > 0 && time.Since(e.LastModified) < opts.MinAge {
+		return SkippedByAge
+	}
+	if opts.NoDeleteMarkers && e.DeleteMarker {
+		return SkippedByMarker
+	}
+	if opts.EmptyFolders && (!strings.HasSuffix(e.Key, "/") || e.Size > 0) {
+		return SkippedByFolder
+	}
+	if len(opts.Suffixes) > 0 && !hasAnySuffix(e.Key, opts.Suffixes) {
+		return SkippedBySuffix
+	}
+	if opts.Include != nil && !opts.Include.MatchString(e.Key) {
+		return SkippedByPattern
+	}
+	if opts.Exclude != nil && opts.Exclude.MatchString(e.Key) {
+		return SkippedByPattern
+	}
+	if opts.Glob != nil && !opts.Glob.MatchString(e.Key) {
+		return SkippedByPattern
+	}
+	for _, p := range opts.ExcludePrefixes {
+		if strings.HasPrefix(e.Key, p) {
+			return SkippedByExcludedPrefix
+		}
+	}
+	if !opts.OlderThan.IsZero() && !e.LastModified.Before(opts.OlderThan) {
+		return SkippedByDate
+	}
+	if !opts.NewerThan.IsZero() && !e.LastModified.After(opts.NewerThan) {
+		return SkippedByDate
+	}
+	if opts.MinSize > 0 || opts.MaxSize > 0 {
+		if e.DeleteMarker {
+			if !opts.SizeFilterMarkers {
+				return SkippedBySize
+			}
+		} else if e.Size < opts.MinSize || (opts.MaxSize > 0 && e.Size > opts.MaxSize) {
+			return SkippedBySize
+		}
+	}
+	if opts.Owner != "" && e.Owner != opts.Owner {
+		return SkippedByOwner
+	}
+	// delete markers have no storage class
+	if len(opts.StorageClasses) > 0 && (e.DeleteMarker || !slices.Contains(opts.StorageClasses, e.StorageClass)) {
+		return SkippedByClass
+	}
+	return ""
+}
+
+func hasAnySuffix(s string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
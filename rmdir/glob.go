@@ -0,0 +1,69 @@
+package rmdir
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// CompileGlob compiles a glob pattern into an expression matching whole keys.
+// As with path.Match, "*" matches any sequence of characters other than "/",
+// "?" any single character other than "/", "[class]" a character class such
+// as [a-z] or [^0-9], and "\c" the character c. In addition, "**" matches any
+// sequence of characters including "/", and "**/" also matches no segment at
+// all, so "a/**/b" matches "a/b".
+func CompileGlob(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString(`^`)
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					b.WriteString(`(?:.*/)?`)
+				} else {
+					b.WriteString(`.*`)
+				}
+			} else {
+				b.WriteString(`[^/]*`)
+			}
+		case '?':
+			b.WriteString(`[^/]`)
+		case '[':
+			// a "]" right after the opening bracket belongs to the class
+			j := i + 1
+			if j < len(pattern) && (pattern[j] == '^' || pattern[j] == '!') {
+				j++
+			}
+			if j < len(pattern) && pattern[j] == ']' {
+				j++
+			}
+			end := strings.IndexByte(pattern[j:], ']')
+			if end < 0 {
+				return nil, errors.New("unterminated character class")
+			}
+			class := pattern[i+1 : j+end]
+			i = j + end
+			b.WriteByte('[')
+			if strings.HasPrefix(class, "^") || strings.HasPrefix(class, "!") {
+				b.WriteByte('^')
+				class = class[1:]
+			}
+			b.WriteString(strings.ReplaceAll(class, `\`, `\\`))
+			b.WriteByte(']')
+		case '\\':
+			i++
+			if i == len(pattern) {
+				return nil, errors.New("trailing backslash")
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString(`$`)
+	return regexp.Compile(b.String())
+}
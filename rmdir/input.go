@@ -0,0 +1,111 @@
+package rmdir
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// inputPageSize is the number of entries read from Options.Input per page.
+const inputPageSize = 1000
+
+// inputPager reads entries from CSV records of the form key or
+// key,versionId. Keys containing commas, quotes or line breaks must be
+// quoted. Keys are used exactly as read: spaces are kept, and neither URL
+// encoding nor "../" is interpreted. A byte order mark at the start of the
+// input is ignored. A CRLF line break within a quoted key is rejected, as
+// encoding/csv would silently read it as LF.
+type inputPager struct {
+	reader *csv.Reader
+	done   bool
+	// err is returned by every page after the one that failed
+	err error
+}
+
+func newInputPager(r io.Reader) *inputPager {
+	reader := csv.NewReader(&crlfGuard{r: r, line: 1})
+	reader.FieldsPerRecord = -1
+	return &inputPager{reader: reader}
+}
+
+func (p *inputPager) HasMorePages() bool {
+	return !p.done
+}
+
+func (p *inputPager) nextPage(ctx context.Context) ([]listEntry, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	entries, err := p.readPage(ctx)
+	if err != nil {
+		p.done = true
+		p.err = err
+	}
+	return entries, err
+}
+
+func (p *inputPager) readPage(ctx context.Context) ([]listEntry, error) {
+	entries := make([]listEntry, 0, inputPageSize)
+	for len(entries) < inputPageSize {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		record, err := p.reader.Read()
+		if errors.Is(err, io.EOF) {
+			p.done = true
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("input: %w", err)
+		}
+		line, _ := p.reader.FieldPos(0)
+		if line == 1 {
+			record[0] = strings.TrimPrefix(record[0], "\ufeff")
+		}
+		switch {
+		case len(record) > 2:
+			return nil, fmt.Errorf("input line %d: expected key or key,versionId", line)
+		case record[0] == "":
+			return nil, fmt.Errorf("input line %d: empty key", line)
+		}
+		e := listEntry{Key: record[0]}
+		if len(record) == 2 {
+			e.VersionId = record[1]
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// crlfGuard passes the input to the CSV reader and fails on a CRLF within
+// quotes. It toggles the quote state at every quote, which also holds for
+// the doubled quotes within a quoted field; the CSV reader rejects the
+// input anyway where that does not hold.
+type crlfGuard struct {
+	r      io.Reader
+	line   int
+	quoted bool
+	// cr is whether the last byte read was a carriage return, which may
+	// be the end of the previous read
+	cr bool
+}
+
+func (g *crlfGuard) Read(p []byte) (int, error) {
+	n, err := g.r.Read(p)
+	for i, b := range p[:n] {
+		switch b {
+		case '"':
+			g.quoted = !g.quoted
+		case '\n':
+			if g.quoted && g.cr {
+				return i, fmt.Errorf("line %d: CRLF line break in a quoted key", g.line)
+			}
+			g.line++
+		}
+		g.cr = b == '\r'
+	}
+	return n, err
+}
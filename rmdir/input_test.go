@@ -0,0 +1,110 @@
+package rmdir
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestInputPager(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []listEntry
+		err   string
+	}{
+		{
+			name:  "keys and versions",
+			input: "a\nb,v1\n\"c,d\",v2\n",
+			want:  []listEntry{{Key: "a"}, {Key: "b", VersionId: "v1"}, {Key: "c,d", VersionId: "v2"}},
+		},
+		{
+			name:  "byte order mark",
+			input: "\ufeffa\n",
+			want:  []listEntry{{Key: "a"}},
+		},
+		{
+			name:  "CRLF line breaks",
+			input: "a\r\nb,v1\r\n",
+			want:  []listEntry{{Key: "a"}, {Key: "b", VersionId: "v1"}},
+		},
+		{
+			name:  "line break in a quoted key",
+			input: "\"a\nb\",v1\n",
+			want:  []listEntry{{Key: "a\nb", VersionId: "v1"}},
+		},
+		{
+			name:  "carriage return in a quoted key",
+			input: "\"a\rb\"\n",
+			want:  []listEntry{{Key: "a\rb"}},
+		},
+		{
+			name:  "quotes in a quoted key",
+			input: "\"a \"\"b\"\"\"\r\nc\r\n",
+			want:  []listEntry{{Key: `a "b"`}, {Key: "c"}},
+		},
+		{
+			name:  "keys used as read",
+			input: " a \n\" b\"\n../c\nd/../e\nf%20g\n%2F\n",
+			want:  []listEntry{{Key: " a "}, {Key: " b"}, {Key: "../c"}, {Key: "d/../e"}, {Key: "f%20g"}, {Key: "%2F"}},
+		},
+		{
+			name:  "CRLF in a quoted key",
+			input: "a\n\"b\r\nc\"\n",
+			err:   "line 2: CRLF line break in a quoted key",
+		},
+		{
+			name:  "empty key",
+			input: "a\n,v1\n",
+			err:   "input line 2: empty key",
+		},
+		{
+			name:  "too many fields",
+			input: "a,v1,x\n",
+			err:   "input line 1: expected key or key,versionId",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// reading a byte at a time splits a CRLF across reads
+			for _, oneByte := range []bool{false, true} {
+				var r io.Reader = strings.NewReader(tt.input)
+				if oneByte {
+					r = iotest.OneByteReader(r)
+				}
+				p := newInputPager(r)
+				var got []listEntry
+				var err error
+				for p.HasMorePages() && err == nil {
+					var page []listEntry
+					page, err = p.nextPage(context.Background())
+					got = append(got, page...)
+				}
+				if tt.err != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.err) {
+						t.Errorf("error %v, want %q", err, tt.err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if formatEntries(got) != formatEntries(tt.want) {
+					t.Errorf("entries %s, want %s", formatEntries(got), formatEntries(tt.want))
+				}
+			}
+		})
+	}
+}
+
+// formatEntries returns the quoted keys and version IDs of entries.
+func formatEntries(entries []listEntry) string {
+	var b strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&b, "[%q %q]", e.Key, e.VersionId)
+	}
+	return b.String()
+}
@@ -0,0 +1,289 @@
+package rmdir
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// listEntry is an object version or delete marker as returned by
+// ListObjectVersions, or an object as returned by ListObjectsV2 with an empty
+// VersionId.
+type listEntry struct {
+	Key          string
+	VersionId    string
+	LastModified time.Time
+	Size         int64
+	DeleteMarker bool
+	StorageClass string
+	// IsLatest is set for the current version of a key.
+	IsLatest bool
+	// Owner is the canonical user ID of the owner, if S3 returned it.
+	Owner string
+}
+
+func ownerID(o *types.Owner) string {
+	if o == nil {
+		return ""
+	}
+	return aws.ToString(o.ID)
+}
+
+func versionEntry(v types.ObjectVersion) listEntry {
+	return listEntry{
+		Key:          aws.ToString(v.Key),
+		VersionId:    aws.ToString(v.VersionId),
+		LastModified: aws.ToTime(v.LastModified),
+		Size:         aws.ToInt64(v.Size),
+		StorageClass: string(v.StorageClass),
+		IsLatest:     aws.ToBool(v.IsLatest),
+		Owner:        ownerID(v.Owner),
+	}
+}
+
+func deleteMarkerEntry(v types.DeleteMarkerEntry) listEntry {
+	return listEntry{
+		Key:          aws.ToString(v.Key),
+		VersionId:    aws.ToString(v.VersionId),
+		LastModified: aws.ToTime(v.LastModified),
+		DeleteMarker: true,
+		IsLatest:     aws.ToBool(v.IsLatest),
+		Owner:        ownerID(v.Owner),
+	}
+}
+
+// pageEntries merges the object versions and delete markers of a page into
+// the order of the listing: by key and, for each key, newest first. Object
+// versions are left out if markersOnly is set.
+func pageEntries(page *s3.ListObjectVersionsOutput, markersOnly bool) []listEntry {
+	versions := page.Versions
+	if markersOnly {
+		versions = nil
+	}
+	entries := make([]listEntry, 0, len(versions)+len(page.DeleteMarkers))
+	i, j := 0, 0
+	for i < len(versions) || j < len(page.DeleteMarkers) {
+		if j == len(page.DeleteMarkers) {
+			entries = append(entries, versionEntry(versions[i]))
+			i++
+			continue
+		}
+		if i == len(versions) {
+			entries = append(entries, deleteMarkerEntry(page.DeleteMarkers[j]))
+			j++
+			continue
+		}
+		v, m := versionEntry(versions[i]), deleteMarkerEntry(page.DeleteMarkers[j])
+		if v.Key < m.Key || (v.Key == m.Key && !v.LastModified.Before(m.LastModified)) {
+			entries = append(entries, v)
+			i++
+		} else {
+			entries = append(entries, m)
+			j++
+		}
+	}
+	return entries
+}
+
+// listRetries is the number of times a failed list request is repeated with
+// Options.ContinueOnListError, in addition to the retries of the SDK.
+const listRetries = 3
+
+// nextPageWithRetries is like p.nextPage, but repeats a failed request with
+// backoff up to listRetries times.
+func nextPageWithRetries(ctx context.Context, p pager) ([]listEntry, error) {
+	for attempt := 1; ; attempt++ {
+		entries, err := p.nextPage(ctx)
+		if err == nil || attempt > listRetries || !sleep(ctx, backoff(attempt)) {
+			return entries, err
+		}
+	}
+}
+
+// resumePager is like newPager, but continues the listing of r after the
+// version versionId of key, the last entry listed by a previous pager.
+func resumePager(client S3API, opts *Options, prefix string, r keyRange, key, versionId string) pager {
+	r = keyRange{After: key, Until: r.Until}
+	if opts.NoVersions || opts.DirectoryBucket {
+		return newPager(client, opts, prefix, r)
+	}
+	params := opts.listObjectVersionsInput(prefix)
+	params.KeyMarker = aws.String(key)
+	params.VersionIdMarker = aws.String(versionId)
+	return &versionPager{
+		paginator: s3.NewListObjectVersionsPaginator(client, params),
+		opts:      opts,
+		keyRange:  r,
+	}
+}
+
+// maxListKeys is the maximum number of entries S3 returns per list request.
+const maxListKeys = 1000
+
+// pager lists the entries selected by the options of a run page by page.
+type pager interface {
+	HasMorePages() bool
+	nextPage(ctx context.Context) ([]listEntry, error)
+}
+
+// newPager reads the entries from opts.Input or opts.FromPlan if set.
+// Otherwise it lists object versions and delete markers, or only the current
+// objects if opts.NoVersions or opts.DirectoryBucket is set.
+// The listing is restricted to the keys in r.
+func newPager(client S3API, opts *Options, prefix string, r keyRange) pager {
+	if opts.Input != nil {
+		return newInputPager(opts.Input)
+	}
+	if opts.FromPlan != nil {
+		return opts.FromPlan
+	}
+	if opts.NoVersions || opts.DirectoryBucket {
+		params := opts.listObjectsV2Input(prefix)
+		if r.After != "" {
+			params.StartAfter = aws.String(r.After)
+		}
+		if opts.StartAfterKey > aws.ToString(params.StartAfter) {
+			params.StartAfter = aws.String(opts.StartAfterKey)
+		}
+		return &objectPager{
+			paginator: s3.NewListObjectsV2Paginator(client, params),
+			opts:      opts,
+			keyRange:  r,
+		}
+	}
+	params := opts.listObjectVersionsInput(prefix)
+	if r.After != "" {
+		params.KeyMarker = aws.String(r.After)
+	}
+	if opts.StartAfterKey > aws.ToString(params.KeyMarker) {
+		params.KeyMarker = aws.String(opts.StartAfterKey)
+		if opts.StartAfterVersion != "" {
+			params.VersionIdMarker = aws.String(opts.StartAfterVersion)
+		}
+	}
+	return &versionPager{
+		paginator: s3.NewListObjectVersionsPaginator(client, params),
+		opts:      opts,
+		keyRange:  r,
+	}
+}
+
+type versionPager struct {
+	paginator *s3.ListObjectVersionsPaginator
+	opts      *Options
+	keyRange  keyRange
+	done      bool
+}
+
+func (p *versionPager) HasMorePages() bool {
+	return !p.done && p.paginator.HasMorePages()
+}
+
+func (p *versionPager) nextPage(ctx context.Context) ([]listEntry, error) {
+	page, err := p.paginator.NextPage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range page.CommonPrefixes {
+		p.opts.logger().Debug("skipping nested prefix", "prefix", aws.ToString(c.Prefix))
+	}
+	var entries []listEntry
+	entries, p.done = p.keyRange.truncate(pageEntries(page, p.opts.MarkersOnly))
+	p.opts.logPage(ctx, len(entries), "next_key_marker", aws.ToString(page.NextKeyMarker),
+		"next_version_id_marker", aws.ToString(page.NextVersionIdMarker))
+	return entries, nil
+}
+
+type objectPager struct {
+	paginator *s3.ListObjectsV2Paginator
+	opts      *Options
+	keyRange  keyRange
+	done      bool
+}
+
+func (p *objectPager) HasMorePages() bool {
+	return !p.done && p.paginator.HasMorePages()
+}
+
+func (p *objectPager) nextPage(ctx context.Context) ([]listEntry, error) {
+	page, err := p.paginator.NextPage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range page.CommonPrefixes {
+		p.opts.logger().Debug("skipping nested prefix", "prefix", aws.ToString(c.Prefix))
+	}
+	entries := make([]listEntry, 0, len(page.Contents))
+	for _, o := range page.Contents {
+		entries = append(entries, listEntry{
+			Key:          aws.ToString(o.Key),
+			LastModified: aws.ToTime(o.LastModified),
+			Size:         aws.ToInt64(o.Size),
+			StorageClass: string(o.StorageClass),
+			IsLatest:     true,
+			Owner:        ownerID(o.Owner),
+		})
+	}
+	entries, p.done = p.keyRange.truncate(entries)
+	last := ""
+	if len(entries) > 0 {
+		last = entries[len(entries)-1].Key
+	}
+	p.opts.logPage(ctx, len(entries), "last_key", last)
+	return entries, nil
+}
+
+// logPage logs the position of the listing after a page of the given
+// number of entries, so that errors can be related to a range of keys and a
+// run can be resumed from there by hand. It logs at info level with Verbose
+// and at debug level otherwise.
+func (opts *Options) logPage(ctx context.Context, entries int, markers ...any) {
+	level := slog.LevelDebug
+	if opts.Verbose {
+		level = slog.LevelInfo
+	}
+	opts.logger().Log(ctx, level, "listed page", append([]any{"entries", entries}, markers...)...)
+}
+
+func (opts *Options) listObjectVersionsInput(prefix string) *s3.ListObjectVersionsInput {
+	return &s3.ListObjectVersionsInput{
+		Bucket:       aws.String(opts.Bucket),
+		Prefix:       aws.String(prefix),
+		RequestPayer: opts.requestPayer(),
+		MaxKeys:      aws.Int32(int32(opts.maxKeys())),
+		Delimiter:    opts.delimiter(),
+	}
+}
+
+func (opts *Options) listObjectsV2Input(prefix string) *s3.ListObjectsV2Input {
+	return &s3.ListObjectsV2Input{
+		Bucket:       aws.String(opts.Bucket),
+		Prefix:       aws.String(prefix),
+		RequestPayer: opts.requestPayer(),
+		MaxKeys:      aws.Int32(int32(opts.maxKeys())),
+		Delimiter:    opts.delimiter(),
+		// the owner is only listed on request
+		FetchOwner: aws.Bool(opts.Owner != ""),
+	}
+}
+
+// maxKeys returns the number of entries per list request, which defaults to
+// maxListKeys.
+func (opts *Options) maxKeys() int {
+	if opts.MaxKeys == 0 {
+		return maxListKeys
+	}
+	return opts.MaxKeys
+}
+
+// delimiter returns the Delimiter parameter of the list requests.
+func (opts *Options) delimiter() *string {
+	if opts.Delimiter == "" {
+		return nil
+	}
+	return aws.String(opts.Delimiter)
+}
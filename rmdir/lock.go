@@ -0,0 +1,64 @@
+package rmdir
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// filterLocked returns the object versions that are not locked and the number
+// of locked versions left out. Delete markers cannot be locked.
+func filterLocked(ctx context.Context, client S3API, opts *Options, objectVersions []ObjectVersion) ([]ObjectVersion, int) {
+	unlocked := make([]ObjectVersion, 0, len(objectVersions))
+	for _, v := range objectVersions {
+		if !v.DeleteMarker && isLocked(ctx, client, opts, v) {
+			continue
+		}
+		unlocked = append(unlocked, v)
+	}
+	return unlocked, len(objectVersions) - len(unlocked)
+}
+
+// isLocked reports whether v is under a legal hold or an unexpired retention
+// period that opts.BypassGovernance does not cover. If the status cannot be
+// determined, e.g. because the bucket has no object lock configuration, v is
+// considered unlocked and left to DeleteObjects.
+func isLocked(ctx context.Context, client S3API, opts *Options, v ObjectVersion) bool {
+	var versionId *string
+	if v.VersionId != "" {
+		versionId = aws.String(v.VersionId)
+	}
+	hold, err := client.GetObjectLegalHold(ctx, &s3.GetObjectLegalHoldInput{
+		Bucket:       aws.String(opts.Bucket),
+		Key:          aws.String(v.Key),
+		VersionId:    versionId,
+		RequestPayer: opts.requestPayer(),
+	})
+	if err == nil && hold.LegalHold != nil && hold.LegalHold.Status == types.ObjectLockLegalHoldStatusOn {
+		return true
+	}
+	retention, err := client.GetObjectRetention(ctx, &s3.GetObjectRetentionInput{
+		Bucket:       aws.String(opts.Bucket),
+		Key:          aws.String(v.Key),
+		VersionId:    versionId,
+		RequestPayer: opts.requestPayer(),
+	})
+	if err != nil {
+		opts.logger().Debug("failed to get object retention", "key", v.Key, "version_id", v.VersionId, "error", err)
+		return false
+	}
+	if retention.Retention == nil || retention.Retention.RetainUntilDate == nil ||
+		!retention.Retention.RetainUntilDate.After(time.Now()) {
+		return false
+	}
+	switch retention.Retention.Mode {
+	case types.ObjectLockRetentionModeCompliance:
+		return true
+	case types.ObjectLockRetentionModeGovernance:
+		return !opts.BypassGovernance
+	}
+	return false
+}
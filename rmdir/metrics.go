@@ -0,0 +1,130 @@
+package rmdir
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics counts the progress of a run. It is served in the Prometheus text
+// exposition format. A nil *Metrics counts nothing.
+type Metrics struct {
+	deleted  atomic.Int64
+	errors   atomic.Int64
+	requests atomic.Int64
+	retries  atomic.Int64
+	inFlight atomic.Int64
+	// peakInFlight is the highest value of inFlight
+	peakInFlight atomic.Int64
+	batches      atomic.Int64
+	// batchTime is the total time spent deleting batches in nanoseconds
+	batchTime atomic.Int64
+	// throttledRequests counts the requests answered with SlowDown
+	throttledRequests atomic.Int64
+	// concurrencyLimit is the current limit of the adaptive throttle
+	concurrencyLimit atomic.Int64
+}
+
+func (m *Metrics) throttled() {
+	if m != nil {
+		m.throttledRequests.Add(1)
+	}
+}
+
+func (m *Metrics) setConcurrencyLimit(n int) {
+	if m != nil {
+		m.concurrencyLimit.Store(int64(n))
+	}
+}
+
+func (m *Metrics) batchStarted() {
+	if m == nil {
+		return
+	}
+	n := m.inFlight.Add(1)
+	for {
+		peak := m.peakInFlight.Load()
+		if n <= peak || m.peakInFlight.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+func (m *Metrics) requestSent() {
+	if m != nil {
+		m.requests.Add(1)
+	}
+}
+
+func (m *Metrics) batchDone(r BatchResult, dryRun bool) {
+	if m == nil {
+		return
+	}
+	m.inFlight.Add(-1)
+	if !dryRun {
+		m.deleted.Add(int64(r.BatchSize - r.numSkipped() - r.ErrorCount))
+	}
+	m.errors.Add(int64(r.ErrorCount))
+	m.retries.Add(int64(r.Retries))
+	m.batches.Add(1)
+	m.batchTime.Add(int64(r.Duration))
+}
+
+// MetricsSnapshot holds the values of Metrics at a point in time.
+type MetricsSnapshot struct {
+	Deleted  int64
+	Errors   int64
+	Requests int64
+	Retries  int64
+	InFlight int64
+	// PeakInFlight is the highest number of batches in flight at a time.
+	PeakInFlight int64
+	// Batches is the number of completed batches.
+	Batches int64
+	// BatchTime is the total time spent deleting the completed batches.
+	BatchTime time.Duration
+	// Throttled is the number of DeleteObjects requests S3 answered with
+	// SlowDown for the request or some of its keys.
+	Throttled int64
+	// ConcurrencyLimit is the current number of concurrent DeleteObjects
+	// requests allowed, which is lowered while S3 throttles.
+	ConcurrencyLimit int64
+}
+
+// Snapshot returns the current values. It is safe to call while a run
+// updates m.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	return MetricsSnapshot{
+		Deleted:          m.deleted.Load(),
+		Errors:           m.errors.Load(),
+		Requests:         m.requests.Load(),
+		Retries:          m.retries.Load(),
+		InFlight:         m.inFlight.Load(),
+		PeakInFlight:     m.peakInFlight.Load(),
+		Batches:          m.batches.Load(),
+		BatchTime:        time.Duration(m.batchTime.Load()),
+		Throttled:        m.throttledRequests.Load(),
+		ConcurrencyLimit: m.concurrencyLimit.Load(),
+	}
+}
+
+// ServeHTTP writes the current values.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics := []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"s3rmdir_objects_deleted_total", "counter", "Object versions deleted.", m.deleted.Load()},
+		{"s3rmdir_errors_total", "counter", "Object versions that could not be deleted.", m.errors.Load()},
+		{"s3rmdir_delete_requests_total", "counter", "DeleteObjects requests sent, including retries.", m.requests.Load()},
+		{"s3rmdir_retries_total", "counter", "DeleteObjects requests retried.", m.retries.Load()},
+		{"s3rmdir_batches_in_flight", "gauge", "Batches being deleted.", m.inFlight.Load()},
+		{"s3rmdir_throttled_requests_total", "counter", "DeleteObjects requests answered with SlowDown.", m.throttledRequests.Load()},
+		{"s3rmdir_concurrency_limit", "gauge", "Concurrent DeleteObjects requests allowed by the adaptive throttle.", m.concurrencyLimit.Load()},
+	}
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", metric.name, metric.help, metric.name, metric.kind, metric.name, metric.value)
+	}
+}
@@ -0,0 +1,127 @@
+package rmdir
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Format selects how progress is written to Options.Output.
+type Format string
+
+const (
+	// FormatText writes human-readable progress lines.
+	FormatText Format = "text"
+	// FormatJSON writes one JSON object per line.
+	FormatJSON Format = "json"
+)
+
+type batchRecord struct {
+	Deleted      int `json:"deleted"`
+	Errors       int `json:"errors"`
+	TotalDeleted int `json:"total_deleted"`
+}
+
+type versionRecord struct {
+	Key       string `json:"key"`
+	VersionId string `json:"version_id"`
+}
+
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	Key       string    `json:"key"`
+	VersionId string    `json:"version_id"`
+}
+
+// writeAuditRecords writes an audit record for each deleted object version.
+func writeAuditRecords(w io.Writer, deleted []ObjectVersion) error {
+	encoder := json.NewEncoder(w)
+	now := time.Now().UTC()
+	for _, v := range deleted {
+		if err := encoder.Encode(auditRecord{Time: now, Key: v.Key, VersionId: v.VersionId}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QuoteKey returns key as is if it prints unambiguously on a line of text
+// output, or as a Go string literal otherwise, e.g. if it contains a line
+// break, a control character, invalid UTF-8 or leading or trailing spaces.
+// Keys are never URL-decoded; a key like "a%20b" is printed as is.
+func QuoteKey(key string) string {
+	if key == "" || !utf8.ValidString(key) ||
+		strings.TrimSpace(key) != key || strings.HasPrefix(key, `"`) {
+		return strconv.Quote(key)
+	}
+	for _, r := range key {
+		if !unicode.IsPrint(r) {
+			return strconv.Quote(key)
+		}
+	}
+	return key
+}
+
+// reportBatch passes a completed batch to opts.OnBatch or prints it.
+// numProcessed and numErrors are the running totals including r.
+func (opts *Options) reportBatch(r BatchResult, numProcessed, numErrors int) {
+	if opts.OnBatch != nil {
+		opts.OnBatch(r)
+		return
+	}
+	if opts.Quiet {
+		return
+	}
+	printBatch(opts.output(), opts.Format, opts.DryRun, opts.Verbose, r, numProcessed, numErrors)
+}
+
+// printBatch writes the report of a completed batch to w, listing every
+// deleted object version if verbose is set. numProcessed and numErrors are
+// the running totals including r.
+func printBatch(w io.Writer, format Format, dryRun, verbose bool, r BatchResult, numProcessed, numErrors int) {
+	if format == FormatJSON {
+		encoder := json.NewEncoder(w)
+		if dryRun {
+			for _, v := range r.DryRun {
+				encoder.Encode(versionRecord{Key: v.Key, VersionId: v.VersionId})
+			}
+			return
+		}
+		if verbose {
+			for _, v := range r.Deleted {
+				encoder.Encode(versionRecord{Key: v.Key, VersionId: v.VersionId})
+			}
+		}
+		encoder.Encode(batchRecord{
+			Deleted:      r.BatchSize - r.numSkipped() - r.ErrorCount,
+			Errors:       r.ErrorCount,
+			TotalDeleted: numProcessed - numErrors,
+		})
+		return
+	}
+	if dryRun {
+		for _, v := range r.DryRun {
+			if v.VersionId == "" {
+				fmt.Fprintf(w, "would delete %s\n", QuoteKey(v.Key))
+			} else {
+				fmt.Fprintf(w, "would delete %s (version %s)\n", QuoteKey(v.Key), v.VersionId)
+			}
+		}
+		return
+	}
+	if verbose {
+		for _, v := range r.Deleted {
+			if v.VersionId == "" {
+				fmt.Fprintf(w, "deleted %s\n", QuoteKey(v.Key))
+			} else {
+				fmt.Fprintf(w, "deleted %s (version %s)\n", QuoteKey(v.Key), v.VersionId)
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d objects deleted, %d errors\n", numProcessed, numErrors)
+}
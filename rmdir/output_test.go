@@ -0,0 +1,30 @@
+package rmdir
+
+import "testing"
+
+func TestQuoteKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"a/b.txt", "a/b.txt"},
+		{"a b", "a b"},
+		{"../a", "../a"},
+		{"a/../b", "a/../b"},
+		{"a%20b", "a%20b"},
+		{"ä/ö", "ä/ö"},
+		{"", `""`},
+		{" a", `" a"`},
+		{"a ", `"a "`},
+		{"a\nb", `"a\nb"`},
+		{"a\r\nb", `"a\r\nb"`},
+		{"a\tb", `"a\tb"`},
+		{`"a"`, `"\"a\""`},
+		{"a\xffb", `"a\xffb"`},
+	}
+	for _, tt := range tests {
+		if got := QuoteKey(tt.key); got != tt.want {
+			t.Errorf("QuoteKey(%q) = %s, want %s", tt.key, got, tt.want)
+		}
+	}
+}
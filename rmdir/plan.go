@@ -0,0 +1,197 @@
+package rmdir
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A plan is a JSON document of the form
+//
+//	{"bucket": "name", "objects": [{"key": ..., "version_id": ...}, ...]}
+//
+// listing the object versions a dry run would delete. It is written and read
+// incrementally, so that plans of large buckets need not fit into memory.
+
+// PlanEntry is an object version of a plan.
+type PlanEntry struct {
+	Key          string    `json:"key"`
+	VersionId    string    `json:"version_id,omitempty"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	DeleteMarker bool      `json:"delete_marker,omitempty"`
+}
+
+// PlanWriter writes a plan, see Options.PlanOutput.
+type PlanWriter struct {
+	w       io.Writer
+	bucket  string
+	started bool
+	n       int
+	err     error
+}
+
+// NewPlanWriter returns a PlanWriter writing the plan of bucket to w.
+func NewPlanWriter(w io.Writer, bucket string) *PlanWriter {
+	return &PlanWriter{w: w, bucket: bucket}
+}
+
+// add appends objectVersions to the plan.
+func (p *PlanWriter) add(objectVersions []ObjectVersion) error {
+	p.writeHeader()
+	for _, v := range objectVersions {
+		b, err := json.Marshal(PlanEntry{
+			Key:          v.Key,
+			VersionId:    v.VersionId,
+			Size:         v.Size,
+			LastModified: v.LastModified,
+			DeleteMarker: v.DeleteMarker,
+		})
+		if err != nil && p.err == nil {
+			p.err = err
+		}
+		if p.err != nil {
+			return p.err
+		}
+		if p.n > 0 {
+			p.write(",\n")
+		}
+		p.write(string(b))
+		p.n++
+	}
+	return p.err
+}
+
+// Close completes the plan. It does not close the underlying writer.
+func (p *PlanWriter) Close() error {
+	p.writeHeader()
+	p.write("\n]}\n")
+	return p.err
+}
+
+func (p *PlanWriter) writeHeader() {
+	if p.started {
+		return
+	}
+	p.started = true
+	bucket, err := json.Marshal(p.bucket)
+	if err != nil {
+		p.err = err
+		return
+	}
+	p.write(fmt.Sprintf("{\"bucket\": %s, \"objects\": [\n", bucket))
+}
+
+func (p *PlanWriter) write(s string) {
+	if p.err == nil {
+		_, p.err = io.WriteString(p.w, s)
+	}
+}
+
+// PlanReader reads a plan, see Options.FromPlan.
+type PlanReader struct {
+	// Bucket is the bucket the plan was made for.
+	Bucket  string
+	decoder *json.Decoder
+	done    bool
+	// err is returned by every call of Next after the one that failed
+	err error
+}
+
+// ReadPlan reads the header of the plan from r and returns a PlanReader for
+// its object versions.
+func ReadPlan(r io.Reader) (*PlanReader, error) {
+	p := &PlanReader{decoder: json.NewDecoder(r)}
+	if err := p.expect(json.Delim('{')); err != nil {
+		return nil, err
+	}
+	for {
+		t, err := p.decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("plan: %w", err)
+		}
+		switch t {
+		case "bucket":
+			if err := p.decoder.Decode(&p.Bucket); err != nil {
+				return nil, fmt.Errorf("plan: bucket: %w", err)
+			}
+		case "objects":
+			if p.Bucket == "" {
+				return nil, errors.New("plan: no bucket before the objects")
+			}
+			if err := p.expect(json.Delim('[')); err != nil {
+				return nil, err
+			}
+			return p, nil
+		default:
+			return nil, fmt.Errorf("plan: unexpected %v", t)
+		}
+	}
+}
+
+func (p *PlanReader) expect(delim json.Delim) error {
+	t, err := p.decoder.Token()
+	if err != nil {
+		return fmt.Errorf("plan: %w", err)
+	}
+	if t != delim {
+		return fmt.Errorf("plan: expected %v, found %v", delim, t)
+	}
+	return nil
+}
+
+// HasMorePages reports whether the plan has more object versions.
+func (p *PlanReader) HasMorePages() bool {
+	return !p.done
+}
+
+// Next returns the next object version of the plan, or io.EOF after the
+// last one.
+func (p *PlanReader) Next() (PlanEntry, error) {
+	if p.err != nil {
+		return PlanEntry{}, p.err
+	}
+	if p.done || !p.decoder.More() {
+		p.done = true
+		return PlanEntry{}, io.EOF
+	}
+	var e PlanEntry
+	if err := p.decoder.Decode(&e); err != nil {
+		p.done = true
+		p.err = fmt.Errorf("plan: %w", err)
+		return PlanEntry{}, p.err
+	}
+	if e.Key == "" {
+		p.done = true
+		p.err = errors.New("plan: empty key")
+		return PlanEntry{}, p.err
+	}
+	return e, nil
+}
+
+func (p *PlanReader) nextPage(ctx context.Context) ([]listEntry, error) {
+	entries := make([]listEntry, 0, inputPageSize)
+	for len(entries) < inputPageSize {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		e, err := p.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, listEntry{
+			Key:          e.Key,
+			VersionId:    e.VersionId,
+			LastModified: e.LastModified,
+			Size:         e.Size,
+			DeleteMarker: e.DeleteMarker,
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,70 @@
+package rmdir
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	progressBarWidth    = 30
+	progressRefreshRate = 200 * time.Millisecond
+	spinnerFrames       = `|/-\`
+)
+
+// progressBar renders the completion of a run on a terminal line. If the
+// total is not known, it renders a spinner with the number of objects done
+// and the rate instead.
+type progressBar struct {
+	w     io.Writer
+	total int
+	// known is set if total has been counted
+	known   bool
+	frame   int
+	start   time.Time
+	updated time.Time
+}
+
+func newProgressBar(w io.Writer, total int) *progressBar {
+	return &progressBar{w: w, total: total, known: true, start: time.Now()}
+}
+
+func newSpinner(w io.Writer) *progressBar {
+	return &progressBar{w: w, start: time.Now()}
+}
+
+// update redraws the bar if the last redraw is long enough ago or if force
+// is set.
+func (p *progressBar) update(done int, force bool) {
+	now := time.Now()
+	if !force && now.Sub(p.updated) < progressRefreshRate {
+		return
+	}
+	p.updated = now
+	elapsed := now.Sub(p.start)
+	rate := float64(done) / elapsed.Seconds()
+	if !p.known {
+		p.frame = (p.frame + 1) % len(spinnerFrames)
+		fmt.Fprintf(p.w, "\r%c %d objects, %.0f objects/s ", spinnerFrames[p.frame], done, rate)
+		return
+	}
+	fraction := 1.0
+	if p.total > 0 {
+		fraction = min(float64(done)/float64(p.total), 1)
+	}
+	filled := int(fraction * progressBarWidth)
+	eta := "?"
+	if rate > 0 && done <= p.total {
+		eta = time.Duration(float64(p.total-done) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+	fmt.Fprintf(p.w, "\r[%s%s] %5.1f%% %d/%d objects, %.0f objects/s, ETA %s ",
+		strings.Repeat("#", filled), strings.Repeat(".", progressBarWidth-filled),
+		fraction*100, done, p.total, rate, eta)
+}
+
+// finish draws the final state and terminates the line.
+func (p *progressBar) finish(done int) {
+	p.update(done, true)
+	fmt.Fprintln(p.w)
+}
@@ -0,0 +1,61 @@
+package rmdir
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 20 * time.Second
+)
+
+var retryables = retry.IsErrorRetryables(retry.DefaultRetryables)
+
+// errBatchTimeout is the error of a DeleteObjects request that exceeded
+// Options.BatchTimeout.
+var errBatchTimeout = errors.New("batch timed out")
+
+// isRetryable reports whether err is a throttling, server side or network
+// error that is worth retrying.
+func isRetryable(err error) bool {
+	return retryables.IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// backoff returns the delay before the given retry attempt (starting at 1),
+// using exponential backoff with full jitter.
+func backoff(attempt int) time.Duration {
+	d := maxBackoff
+	if attempt < 16 {
+		d = min(minBackoff<<attempt, maxBackoff)
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// sleep waits for d or until ctx is done, whichever happens first. It reports
+// whether the full duration has elapsed.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// isRetryableCode reports whether the error code of a single key in a
+// DeleteObjects response denotes a transient failure.
+func isRetryableCode(code string) bool {
+	switch code {
+	case "InternalError", "ServiceUnavailable", "SlowDown", "RequestTimeout":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,1205 @@
+// Package rmdir deletes all object versions below a prefix of an S3 bucket.
+package rmdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"golang.org/x/time/rate"
+)
+
+// Options configures DeleteVersions.
+type Options struct {
+	// Bucket to delete from.
+	Bucket string
+	// Prefixes of the keys to delete, which are listed one after the other.
+	// No prefix or an empty prefix selects the whole bucket. Prefixes
+	// cannot be combined with Input or FromPlan.
+	Prefixes []string
+	// BatchSize is the number of object versions per batch. Batches larger
+	// than 1000 are deleted with several DeleteObjects requests.
+	BatchSize int
+	// Concurrency is the number of workers sending DeleteObjects requests.
+	Concurrency int
+	// DryRun lists the object versions that would be deleted without
+	// deleting them.
+	DryRun bool
+	// SimulateErrorRate fails the given share of the object versions of a
+	// dry run at random with the code SimulatedError, between 0 and 1. It
+	// is a testing aid for the handling of failures downstream, such as
+	// exit codes and error logs, and has no effect outside of dry runs.
+	SimulateErrorRate float64
+	// StopOnError aborts the run as soon as a DeleteObjects request fails.
+	StopOnError bool
+	// MaxRetries is the maximum number of times a DeleteObjects request is
+	// retried after a throttling, server side or network error. Keys that
+	// failed transiently in an otherwise successful request are resent in
+	// a retry as well.
+	MaxRetries int
+	// BatchTimeout bounds every DeleteObjects request, unless it is zero. A
+	// request that times out is retried like a network error; if the
+	// retries are exhausted, its keys fail with the code BatchTimeout.
+	BatchTimeout time.Duration
+	// Format of the progress written to stdout; defaults to FormatText.
+	Format Format
+	// Quiet suppresses the output of the batches; only the summary is left.
+	Quiet bool
+	// Verbose lists every deleted object version in the output of the
+	// batches and logs the list markers of every page at info level.
+	Verbose bool
+	// Progress renders the number of object versions processed and the rate
+	// to stderr.
+	Progress bool
+	// Precount counts the object versions in a first listing pass, so that
+	// Progress can render a progress bar with ETA instead of a spinner. On a
+	// large bucket this doubles the listing work and delays the start of
+	// the deletion, which is why it is opt-in. It cannot be combined with
+	// Input or FromPlan.
+	Precount bool
+	// OlderThan restricts the deletion to object versions and delete markers
+	// last modified before the given time, unless it is zero.
+	OlderThan time.Time
+	// NewerThan restricts the deletion to object versions and delete markers
+	// last modified after the given time, unless it is zero.
+	NewerThan time.Time
+	// WrittenBefore protects object versions and delete markers last
+	// modified after the given time, unless it is zero. It is meant to be
+	// set to the start of the run, so that objects written by applications
+	// while the run is in progress survive it.
+	WrittenBefore time.Time
+	// MinAge protects object versions and delete markers last modified less
+	// than the given duration before they are listed, unless it is zero.
+	// Unlike OlderThan it is a safety net that is meant to stay set for
+	// every run on an active prefix, e.g. to an hour.
+	MinAge time.Duration
+	// NoncurrentOlderThan restricts the deletion to noncurrent object
+	// versions and delete markers that were superseded by a newer version
+	// before the given time, unless it is zero. Like a lifecycle rule, this
+	// keeps the current version of every key. It requires the versions to be
+	// listed, so it cannot be combined with MarkersOnly or NoVersions.
+	NoncurrentOlderThan time.Time
+	// MinSize restricts the deletion to object versions of at least the
+	// given number of bytes.
+	MinSize int64
+	// MaxSize restricts the deletion to object versions of at most the given
+	// number of bytes, unless it is zero.
+	MaxSize int64
+	// SizeFilterMarkers includes delete markers, which have no size, when
+	// MinSize or MaxSize is set. Otherwise they are skipped.
+	SizeFilterMarkers bool
+	// Suffixes restricts the deletion to keys ending in one of the given
+	// suffixes, unless it is empty.
+	Suffixes []string
+	// Include restricts the deletion to keys matching the expression, unless
+	// it is nil.
+	Include *regexp.Regexp
+	// Exclude protects keys matching the expression, unless it is nil.
+	Exclude *regexp.Regexp
+	// ExcludePrefixes protects keys starting with one of the given prefixes,
+	// e.g. "logs/archive/" while deleting from "logs/".
+	ExcludePrefixes []string
+	// Glob restricts the deletion to keys matching the expression, unless it
+	// is nil. It is meant to be compiled with CompileGlob.
+	Glob *regexp.Regexp
+	// Owner restricts the deletion to object versions and delete markers
+	// owned by the given canonical user ID, unless it is empty. S3 only
+	// lists the owner if the caller may read the object ACLs; with the
+	// bucket owner enforced setting all objects are owned by the bucket
+	// owner. Entries without an owner, such as those of Input, are skipped.
+	Owner string
+	// StorageClasses restricts the deletion to object versions in one of the
+	// given storage classes, e.g. GLACIER, unless it is empty. Delete markers
+	// are skipped. Archived objects are deleted without restoring them.
+	StorageClasses []string
+	// EmptyFolders restricts the deletion to the zero-byte placeholder
+	// objects that some tools create for folders, whose keys end in "/",
+	// and their delete markers. The objects in the folders are kept.
+	EmptyFolders bool
+	// MarkersOnly deletes only delete markers and keeps all object versions.
+	// In a versioned bucket this restores the most recent version of every
+	// deleted object.
+	MarkersOnly bool
+	// NoDeleteMarkers keeps all delete markers and deletes only object
+	// versions. Keys whose current version is a delete marker stay deleted
+	// instead of reappearing with an older version, and their markers
+	// remain listed. It cannot be combined with MarkersOnly.
+	NoDeleteMarkers bool
+	// Keep protects the given number of most recent versions of every key,
+	// counting delete markers as versions. With Keep > 0 the current state of
+	// the objects is left intact and only noncurrent versions are deleted.
+	Keep int
+	// LatestOnly deletes only the current version of every key and keeps
+	// the noncurrent versions. The version is deleted permanently by its
+	// version ID, so the previous version of the key becomes current again;
+	// keys whose current version is a delete marker are skipped. Unlike
+	// NoVersions, no delete markers are created. It needs a full version
+	// listing, so it cannot be combined with MarkersOnly, NoVersions, Keep,
+	// NoncurrentOlderThan or Input.
+	LatestOnly bool
+	// BypassGovernance deletes object versions locked in governance mode.
+	// Locks in compliance mode and legal holds cannot be bypassed.
+	BypassGovernance bool
+	// RequestPayer acknowledges that the requester pays for listing and
+	// deleting in a Requester Pays bucket.
+	RequestPayer bool
+	// Tags restricts the deletion to object versions carrying all of the
+	// given tags, unless it is empty. The tags are fetched with an additional
+	// request per object version; delete markers have no tags and are
+	// skipped.
+	Tags map[string]string
+	// SkipLocked checks the object lock status of every object version
+	// before deleting it and skips the versions under a legal hold or an
+	// unexpired retention period that BypassGovernance does not cover. This
+	// costs up to two additional requests per object version.
+	SkipLocked bool
+	// Rate limits the deletion to the given number of object versions per
+	// second, unless it is zero.
+	Rate float64
+	// MaxObjects stops the run after the given number of object versions
+	// have been submitted for deletion, unless it is zero.
+	MaxObjects int
+	// NoVersions lists the current objects with ListObjectsV2 and deletes
+	// them without version IDs. This is meant for buckets that have never
+	// been versioned; in a versioned bucket it creates delete markers.
+	NoVersions bool
+	// DirectoryBucket marks Bucket as an S3 Express One Zone directory
+	// bucket. Directory buckets are not versioned and do not list keys in
+	// lexicographic order, so the run lists the objects with ListObjectsV2
+	// like NoVersions, and Shards and checkpoints are not supported.
+	DirectoryBucket bool
+	// Delimiter restricts the deletion to the keys directly below each
+	// prefix, unless it is empty. Keys containing the delimiter after the
+	// prefix are grouped by S3 into common prefixes, which are skipped. Like
+	// rm without -r, this also spares the delete markers and the folder
+	// placeholder objects of the nested prefixes.
+	Delimiter string
+	// MaxKeys is the number of entries per list request, at most 1000. It
+	// defaults to 1000 and is independent of BatchSize.
+	MaxKeys int
+	// SamplePages stops Tally after the given number of pages per prefix,
+	// unless it is zero. DeleteVersions ignores it.
+	SamplePages int
+	// ContinueOnListError retries a list request that keeps failing a few
+	// more times and then lists its page again entry by entry. If an entry
+	// cannot be listed either, the rest of its key range, up to the next
+	// shard boundary or the end of the prefix, is given up instead of
+	// aborting the run. The ranges given up are reported in
+	// Summary.ListFailures. A malformed entry of Input or FromPlan always
+	// fails the run.
+	ContinueOnListError bool
+	// StartAfterKey starts the listing of every prefix after the given key,
+	// unless it is empty. S3 lists keys in lexicographic order of their
+	// UTF-8 bytes, so only the keys sorting after it are deleted. Together
+	// with a checkpoint or a shard, the later of the two starts applies.
+	StartAfterKey string
+	// StartAfterVersion starts the listing after the given version of
+	// StartAfterKey instead of after all of its versions, unless it is
+	// empty. The versions of a key are listed newest first.
+	StartAfterVersion string
+	// Shards splits the keys of every prefix at the given ascending
+	// boundaries, which are relative to the prefix, and lists the resulting
+	// ranges concurrently. Listing is usually the bottleneck of a run, but
+	// sharding only helps if the keys are spread evenly over the ranges.
+	Shards []string
+	// Input provides the object versions to delete as CSV records of the form
+	// key or key,versionId instead of listing the bucket. Input is read as
+	// the run proceeds; it cannot be combined with Precount. The records
+	// carry no dates, sizes, storage classes or delete marker flags, so
+	// only the key based filters can be combined with Input.
+	Input io.Reader
+	// FromPlan provides the object versions to delete from a plan written by
+	// a dry run with PlanOutput instead of listing the bucket. Like Input, it
+	// is read as the run proceeds. The plan has the dates, sizes and delete
+	// marker flags of the listed versions, but no storage classes.
+	FromPlan *PlanReader
+	// PlanOutput receives every object version a dry run would delete,
+	// unless it is nil. The caller closes it after the run.
+	PlanOutput *PlanWriter
+	// OnBatch is called with every completed batch instead of writing the
+	// progress to stdout, unless it is nil. It is called from a single
+	// goroutine.
+	OnBatch func(BatchResult)
+	// Resume continues the run recorded by a checkpoint, skipping the keys
+	// that have already been processed. It cannot be combined with Input.
+	Resume *Checkpoint
+	// OnCheckpoint is called every few seconds with the progress of the run
+	// and once more at its end, unless it is nil or the run is a dry run. It
+	// is called from a single goroutine. It cannot be combined with Input.
+	OnCheckpoint func(Checkpoint)
+	// AuditLog receives a JSON record with the time, key and version ID of
+	// every deleted object version, unless it is nil.
+	AuditLog io.Writer
+	// Metrics is updated as the run proceeds, unless it is nil.
+	Metrics *Metrics
+	// Logger receives debug messages for every enqueued key and sent batch,
+	// periodic progress at info level and batch failures as warnings. It
+	// defaults to slog.Default().
+	Logger *slog.Logger
+	// Strict aborts the run if S3 lists a key outside of the requested
+	// prefix. Otherwise such keys are skipped with a warning and counted in
+	// Summary.Skipped.
+	Strict bool
+	// FolderSummary breaks the totals of every prefix down by the first
+	// level of folders below it in Summary.Folders.
+	FolderSummary bool
+	// Slots bounds the number of batches being deleted at a time across all
+	// runs sharing it, unless it is nil. A worker holds a slot of the
+	// buffered channel while deleting a batch, in addition to the limit of
+	// Concurrency.
+	Slots chan struct{}
+	// Output receives the report of every batch. It defaults to os.Stdout.
+	Output io.Writer
+	// ErrOutput receives the progress bar. It defaults to os.Stderr.
+	ErrOutput io.Writer
+}
+
+// progressLogInterval is the minimum time between two progress messages.
+const progressLogInterval = 10 * time.Second
+
+func (opts *Options) logger() *slog.Logger {
+	if opts.Logger != nil {
+		return opts.Logger
+	}
+	return slog.Default()
+}
+
+// explicit reports whether the object versions to delete are given by
+// opts.Input or opts.FromPlan rather than listed.
+func (opts *Options) explicit() bool {
+	return opts.Input != nil || opts.FromPlan != nil
+}
+
+func (opts *Options) output() io.Writer {
+	if opts.Output != nil {
+		return opts.Output
+	}
+	return os.Stdout
+}
+
+func (opts *Options) errOutput() io.Writer {
+	if opts.ErrOutput != nil {
+		return opts.ErrOutput
+	}
+	return os.Stderr
+}
+
+func (opts *Options) requestPayer() types.RequestPayer {
+	if opts.RequestPayer {
+		return types.RequestPayerRequester
+	}
+	return ""
+}
+
+// Summary holds the totals of a run.
+type Summary struct {
+	// Objects is the number of object versions submitted for deletion.
+	Objects int
+	// Errors is the number of object versions that could not be deleted.
+	Errors int
+	// Retries is the total number of DeleteObjects retries.
+	Retries int
+	// Locked is the number of errors caused by an object lock retention or
+	// legal hold.
+	Locked int
+	// Skipped counts the listed entries that were not deleted, by the name
+	// of the filter that rejected them.
+	Skipped map[string]int
+	// Failures lists the object versions that could not be deleted.
+	Failures []Failure
+	// ErrorCodes breaks Errors down by S3 error code, e.g. AccessDenied or
+	// SlowDown. Failed requests are counted under the code of the request
+	// error for every object version they contained.
+	ErrorCodes map[string]int
+	// Capped is set if the run stopped at Options.MaxObjects.
+	Capped bool
+	// Prefixes breaks the totals down by prefix.
+	Prefixes map[string]PrefixSummary
+	// Folders breaks the totals down by the first level of folders below
+	// each prefix if Options.FolderSummary is set, e.g. "logs/2023/" for
+	// the prefix "logs/". The keys directly below a prefix are counted under
+	// the prefix itself. Objects counts the object versions deleted rather
+	// than submitted.
+	Folders map[string]PrefixSummary
+	// Bytes is the total size of the object versions deleted, or of those
+	// that would be deleted in dry-run mode. Delete markers have no size.
+	Bytes int64
+	// ListFailures describes the key ranges that could not be listed with
+	// Options.ContinueOnListError.
+	ListFailures []ListFailure
+}
+
+// ListFailure describes the keys of Prefix after After and up to Until, which
+// is empty for the end of the prefix, that could not be listed.
+type ListFailure struct {
+	Prefix  string `json:"prefix"`
+	After   string `json:"after"`
+	Until   string `json:"until"`
+	Message string `json:"message"`
+}
+
+// PrefixSummary holds the totals of a single prefix.
+type PrefixSummary struct {
+	// Objects is the number of object versions submitted for deletion.
+	Objects int `json:"objects"`
+	// Errors is the number of object versions that could not be deleted.
+	Errors int `json:"errors"`
+	// Bytes is the total size of the object versions deleted.
+	Bytes int64 `json:"bytes"`
+}
+
+// prefixes returns the prefixes to list.
+func (opts *Options) prefixes() []string {
+	if len(opts.Prefixes) == 0 {
+		return []string{""}
+	}
+	return opts.Prefixes
+}
+
+// Failure describes an object version that could not be deleted.
+type Failure struct {
+	Key       string `json:"key"`
+	VersionId string `json:"version_id"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+}
+
+// ObjectVersion identifies an object version to delete.
+type ObjectVersion struct {
+	Key          string
+	VersionId    string
+	Size         int64
+	LastModified time.Time
+	DeleteMarker bool
+}
+
+// BatchResult describes a completed batch.
+type BatchResult struct {
+	// Prefix is the prefix the batch was listed from.
+	Prefix string
+	// BatchSize is the number of object versions in the batch.
+	BatchSize int
+	// ErrorCount is the number of object versions that could not be deleted.
+	ErrorCount int
+	// Err is set if a DeleteObjects request failed as a whole, in which case
+	// every object version it contained counts as an error.
+	Err error
+	// Retries is the number of times the request was retried.
+	Retries int
+	// LockedCount is the number of errors caused by object lock.
+	LockedCount int
+	// Failures describes the object versions that could not be deleted.
+	Failures []Failure
+	// ErrorCodes counts Failures by their S3 error code.
+	ErrorCodes map[string]int
+	// Duration is the time spent on the batch, including the lock and tag
+	// checks and retries.
+	Duration time.Duration
+	// DryRun holds the object versions that would have been deleted when
+	// running in dry-run mode.
+	DryRun []ObjectVersion
+	// Deleted holds the object versions that have been deleted.
+	Deleted []ObjectVersion
+	// Bytes is the total size of the object versions deleted.
+	Bytes int64
+	// Skipped counts the object versions left out by the checks of
+	// Options.SkipLocked and Options.Tags, by the name of the filter.
+	Skipped map[string]int
+
+	position batchPosition
+}
+
+// skip records that n object versions of the batch were left out by filter.
+func (r *BatchResult) skip(filter string, n int) {
+	if n == 0 {
+		return
+	}
+	if r.Skipped == nil {
+		r.Skipped = make(map[string]int)
+	}
+	r.Skipped[filter] += n
+}
+
+// numSkipped returns the number of object versions left out.
+func (r *BatchResult) numSkipped() int {
+	n := 0
+	for _, c := range r.Skipped {
+		n += c
+	}
+	return n
+}
+
+// deleteJob is a batch of object versions listed from prefix.
+type deleteJob struct {
+	prefix         string
+	objectVersions []ObjectVersion
+	position       batchPosition
+}
+
+// MaxDeleteKeys is the maximum number of keys of a DeleteObjects request.
+const MaxDeleteKeys = 1000
+
+// deleteObjectVersions deletes a batch, splitting it into several
+// DeleteObjects requests if it is larger than MaxDeleteKeys, and sends the
+// result to resultChannel.
+func deleteObjectVersions(
+	ctx context.Context,
+	resultChannel chan BatchResult,
+	client S3API,
+	limiter *rate.Limiter,
+	throttle *throttle,
+	opts *Options,
+	job deleteJob,
+) {
+	start := time.Now()
+	objectVersions := job.objectVersions
+	r := BatchResult{Prefix: job.prefix, BatchSize: len(objectVersions), position: job.position}
+	if opts.SkipLocked {
+		var n int
+		objectVersions, n = filterLocked(ctx, client, opts, objectVersions)
+		r.skip(SkippedByLock, n)
+	}
+	if len(opts.Tags) > 0 {
+		var n int
+		objectVersions, n = filterTags(ctx, client, opts, objectVersions)
+		r.skip(SkippedByTag, n)
+	}
+	if opts.DryRun {
+		if opts.SimulateErrorRate > 0 {
+			objectVersions, r.Failures = simulateErrors(objectVersions, opts.SimulateErrorRate)
+			r.ErrorCount = len(r.Failures)
+			if r.ErrorCount > 0 {
+				r.ErrorCodes = map[string]int{simulatedErrorCode: r.ErrorCount}
+			}
+		}
+		r.DryRun = objectVersions
+		r.Bytes = totalSize(objectVersions)
+		r.Duration = time.Since(start)
+		resultChannel <- r
+		return
+	}
+	for start := 0; start < len(objectVersions); start += MaxDeleteKeys {
+		chunk := objectVersions[start:min(start+MaxDeleteKeys, len(objectVersions))]
+		failures, locked, retries, err := deleteChunk(ctx, client, limiter, throttle, opts, chunk)
+		r.Failures = append(r.Failures, failures...)
+		r.LockedCount += locked
+		r.Retries += retries
+		if r.Err == nil {
+			r.Err = err
+		}
+	}
+	r.ErrorCount = len(r.Failures)
+	for _, f := range r.Failures {
+		if r.ErrorCodes == nil {
+			r.ErrorCodes = make(map[string]int)
+		}
+		r.ErrorCodes[f.Code]++
+	}
+	r.Deleted = deleted(objectVersions, r.Failures)
+	r.Bytes = totalSize(r.Deleted)
+	r.Duration = time.Since(start)
+	resultChannel <- r
+}
+
+// deleteChunk deletes up to MaxDeleteKeys object versions with a
+// DeleteObjects request. It returns the object versions that could not be
+// deleted, how many of them are locked, the number of retries and the error
+// of the request if it failed as a whole.
+func deleteChunk(
+	ctx context.Context,
+	client S3API,
+	limiter *rate.Limiter,
+	throttle *throttle,
+	opts *Options,
+	objectVersions []ObjectVersion,
+) (failures []Failure, locked, retries int, err error) {
+	params := s3.DeleteObjectsInput{
+		Bucket:       aws.String(opts.Bucket),
+		RequestPayer: opts.requestPayer(),
+	}
+	if opts.BypassGovernance {
+		params.BypassGovernanceRetention = aws.Bool(true)
+	}
+	// a request that has been sent is allowed to finish after ctx is
+	// canceled, but it is not retried anymore
+	requestCtx := context.WithoutCancel(ctx)
+	// pending holds the object versions of the next request; after a
+	// partial failure only the keys that failed transiently are resent
+	pending := objectVersions
+	for {
+		if err := limiter.WaitN(ctx, len(pending)); err != nil {
+			return append(failures, requestFailures(pending, err)...), locked, retries, err
+		}
+		if err := throttle.acquire(ctx); err != nil {
+			return append(failures, requestFailures(pending, err)...), locked, retries, err
+		}
+		params.Delete = deleteParam(pending)
+		opts.logger().Debug("sending batch", "objects", len(pending), "attempt", retries+1)
+		opts.Metrics.requestSent()
+		callCtx, cancelCall := requestCtx, func() {}
+		if opts.BatchTimeout > 0 {
+			callCtx, cancelCall = context.WithTimeout(requestCtx, opts.BatchTimeout)
+		}
+		result, err := client.DeleteObjects(callCtx, &params)
+		if err != nil && callCtx.Err() != nil {
+			err = fmt.Errorf("%w after %s", errBatchTimeout, opts.BatchTimeout)
+		}
+		cancelCall()
+		throttled := err != nil && isThrottling(err)
+		if err == nil {
+			for _, e := range result.Errors {
+				throttled = throttled || aws.ToString(e.Code) == "SlowDown"
+			}
+		}
+		throttle.release(throttled)
+		if err != nil {
+			if retries >= opts.MaxRetries || !(isRetryable(err) || errors.Is(err, errBatchTimeout)) || !sleep(ctx, backoff(retries+1)) {
+				return append(failures, requestFailures(pending, err)...), locked, retries, err
+			}
+			retries++
+			continue
+		}
+		var retry []ObjectVersion
+		var retryFailures []Failure
+		for _, e := range result.Errors {
+			f := Failure{
+				Key:       aws.ToString(e.Key),
+				VersionId: aws.ToString(e.VersionId),
+				Code:      aws.ToString(e.Code),
+				Message:   aws.ToString(e.Message),
+			}
+			if isRetryableCode(f.Code) {
+				retry = append(retry, ObjectVersion{Key: f.Key, VersionId: f.VersionId})
+				retryFailures = append(retryFailures, f)
+				continue
+			}
+			if isObjectLockError(e) {
+				locked++
+			}
+			failures = append(failures, f)
+		}
+		if len(retry) == 0 || retries >= opts.MaxRetries || !sleep(ctx, backoff(retries+1)) {
+			return append(failures, retryFailures...), locked, retries, nil
+		}
+		retries++
+		pending = retry
+	}
+}
+
+// deleteParam returns the Delete parameter of a DeleteObjects request for
+// objectVersions.
+func deleteParam(objectVersions []ObjectVersion) *types.Delete {
+	d := &types.Delete{
+		Objects: make([]types.ObjectIdentifier, 0, len(objectVersions)),
+		Quiet:   aws.Bool(true),
+	}
+	for _, v := range objectVersions {
+		identifier := types.ObjectIdentifier{Key: aws.String(v.Key)}
+		if v.VersionId != "" {
+			identifier.VersionId = aws.String(v.VersionId)
+		}
+		d.Objects = append(d.Objects, identifier)
+	}
+	return d
+}
+
+// deleted returns the object versions that are not listed in failures.
+func deleted(objectVersions []ObjectVersion, failures []Failure) []ObjectVersion {
+	failed := make(map[ObjectVersion]bool, len(failures))
+	for _, f := range failures {
+		failed[ObjectVersion{Key: f.Key, VersionId: f.VersionId}] = true
+	}
+	deleted := make([]ObjectVersion, 0, len(objectVersions))
+	for _, v := range objectVersions {
+		if !failed[ObjectVersion{Key: v.Key, VersionId: v.VersionId}] {
+			deleted = append(deleted, v)
+		}
+	}
+	return deleted
+}
+
+// addFolders adds the object versions of r to the totals of their folders.
+func addFolders(folders map[string]PrefixSummary, r BatchResult) {
+	// only one of Deleted and DryRun is set
+	for _, versions := range [][]ObjectVersion{r.Deleted, r.DryRun} {
+		for _, v := range versions {
+			name := folderOf(r.Prefix, v.Key)
+			f := folders[name]
+			f.Objects++
+			f.Bytes += v.Size
+			folders[name] = f
+		}
+	}
+	for _, failure := range r.Failures {
+		name := folderOf(r.Prefix, failure.Key)
+		f := folders[name]
+		f.Errors++
+		folders[name] = f
+	}
+}
+
+// folderOf returns the first level folder of key below prefix, or prefix for
+// keys directly below it.
+func folderOf(prefix, key string) string {
+	rest := strings.TrimPrefix(key, prefix)
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		return prefix + rest[:i+1]
+	}
+	return prefix
+}
+
+// totalSize returns the total size of objectVersions.
+func totalSize(objectVersions []ObjectVersion) int64 {
+	var n int64
+	for _, v := range objectVersions {
+		n += v.Size
+	}
+	return n
+}
+
+// requestFailures describes every object version of a batch whose
+// DeleteObjects request failed with err.
+func requestFailures(objectVersions []ObjectVersion, err error) []Failure {
+	code := "RequestError"
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code = apiErr.ErrorCode()
+	} else if errors.Is(err, errBatchTimeout) {
+		code = "BatchTimeout"
+	}
+	failures := make([]Failure, 0, len(objectVersions))
+	for _, v := range objectVersions {
+		failures = append(failures, Failure{
+			Key:       v.Key,
+			VersionId: v.VersionId,
+			Code:      code,
+			Message:   err.Error(),
+		})
+	}
+	return failures
+}
+
+// isObjectLockError reports whether e is caused by an object lock retention
+// or legal hold.
+func isObjectLockError(e types.Error) bool {
+	return aws.ToString(e.Code) == "AccessDenied" &&
+		strings.Contains(strings.ToLower(aws.ToString(e.Message)), "object lock")
+}
+
+// DeleteVersions deletes all object versions and delete markers below
+// opts.Prefixes. If ctx is canceled, no further batches are submitted, the
+// batches in flight are allowed to finish and the partial summary is returned
+// together with the context's error.
+func DeleteVersions(ctx context.Context, client S3API, opts Options) (Summary, error) {
+	if opts.Bucket == "" {
+		return Summary{}, errors.New("no bucket given")
+	}
+	if opts.BatchSize <= 0 {
+		return Summary{}, fmt.Errorf("illegal batch size: %d", opts.BatchSize)
+	}
+	if opts.Concurrency <= 0 {
+		return Summary{}, fmt.Errorf("illegal concurrency: %d", opts.Concurrency)
+	}
+	if opts.MaxKeys < 0 || opts.MaxKeys > maxListKeys {
+		return Summary{}, fmt.Errorf("illegal max keys: %d", opts.MaxKeys)
+	}
+	if opts.Input != nil && opts.FromPlan != nil {
+		return Summary{}, errors.New("input entries cannot be combined with a plan")
+	}
+	if opts.FromPlan != nil && opts.FromPlan.Bucket != opts.Bucket {
+		return Summary{}, fmt.Errorf("plan is for bucket %s", opts.FromPlan.Bucket)
+	}
+	if opts.LatestOnly && (opts.MarkersOnly || opts.NoVersions || opts.DirectoryBucket || opts.Keep > 0 ||
+		!opts.NoncurrentOlderThan.IsZero() || opts.explicit()) {
+		return Summary{}, errors.New("the latest versions can only be selected from a full version listing")
+	}
+	if opts.StartAfterVersion != "" && (opts.StartAfterKey == "" || opts.NoVersions || opts.DirectoryBucket) {
+		return Summary{}, errors.New("a version to start after requires a key and a version listing")
+	}
+	if len(opts.Prefixes) > 0 && opts.explicit() {
+		return Summary{}, errors.New("input entries cannot be restricted to prefixes")
+	}
+	if opts.StartAfterKey != "" && opts.explicit() {
+		return Summary{}, errors.New("input entries cannot start after a key")
+	}
+	if opts.NoDeleteMarkers && opts.MarkersOnly {
+		return Summary{}, errors.New("delete markers cannot be both kept and deleted exclusively")
+	}
+	if !opts.NoncurrentOlderThan.IsZero() && (opts.MarkersOnly || opts.NoVersions || opts.explicit()) {
+		return Summary{}, errors.New("noncurrent versions can only be selected from a full version listing")
+	}
+	if err := validateShards(opts.Shards); err != nil {
+		return Summary{}, err
+	}
+	if opts.DirectoryBucket {
+		switch {
+		case opts.MarkersOnly || opts.Keep > 0 || !opts.NoncurrentOlderThan.IsZero():
+			return Summary{}, errors.New("directory buckets have no object versions")
+		case len(opts.Shards) > 0:
+			return Summary{}, errors.New("directory buckets cannot be sharded, their keys are not listed in order")
+		case opts.Resume != nil || opts.OnCheckpoint != nil:
+			return Summary{}, errors.New("checkpoints are not supported for directory buckets, their keys are not listed in order")
+		case opts.StartAfterKey != "" || opts.ContinueOnListError:
+			// a failed listing is continued after the last key listed
+			return Summary{}, errors.New("listings of directory buckets cannot start after a key, their keys are not listed in order")
+		case opts.RequestPayer:
+			return Summary{}, errors.New("directory buckets do not support request payment")
+		}
+	}
+	if opts.Owner != "" && opts.explicit() {
+		return Summary{}, errors.New("input entries have no owner")
+	}
+	if opts.Input != nil && (!opts.OlderThan.IsZero() || !opts.NewerThan.IsZero() || !opts.WrittenBefore.IsZero() ||
+		opts.MinAge > 0 || opts.MinSize > 0 || opts.MaxSize > 0 || len(opts.StorageClasses) > 0 ||
+		opts.MarkersOnly || opts.NoDeleteMarkers || opts.EmptyFolders) {
+		return Summary{}, errors.New("input entries have no dates, sizes, storage classes or delete marker flags to filter by")
+	}
+	if opts.FromPlan != nil && len(opts.StorageClasses) > 0 {
+		return Summary{}, errors.New("plan entries have no storage class")
+	}
+	if opts.Progress && opts.Precount && opts.explicit() {
+		return Summary{}, errors.New("input entries cannot be counted in advance")
+	}
+	if (opts.Resume != nil || opts.OnCheckpoint != nil) && opts.explicit() {
+		return Summary{}, errors.New("checkpoints are not supported for input entries")
+	}
+	if opts.Resume != nil && opts.Resume.Bucket != opts.Bucket {
+		return Summary{}, fmt.Errorf("checkpoint is for bucket %s", opts.Resume.Bucket)
+	}
+
+	var bar *progressBar
+	switch {
+	case opts.Progress && opts.Precount:
+		total, err := CountVersions(ctx, client, opts)
+		if err != nil {
+			return Summary{}, err
+		}
+		bar = newProgressBar(opts.errOutput(), total)
+	case opts.Progress:
+		bar = newSpinner(opts.errOutput())
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	limiter := rate.NewLimiter(rate.Inf, opts.BatchSize)
+	if opts.Rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.Rate), opts.BatchSize)
+	}
+	// the workers back off together when S3 throttles
+	throttle := newThrottle(opts.Concurrency, opts.Metrics)
+	// a slot per worker lets every worker hand over a result without
+	// waiting for the collector, which drains results independently of the
+	// listing, so a full buffer only slows the workers down
+	results := make(chan BatchResult, opts.Concurrency)
+	// a fixed pool of workers sends the DeleteObjects requests; batches is
+	// unbuffered, so the listing blocks while all workers are busy
+	batches := make(chan deleteJob)
+	// the buffers of deleted batches are reused for listing, so there are
+	// about Concurrency plus a buffer per listed range; in dry-run mode the
+	// batches are handed to the results and cannot be reused
+	batchPool := sync.Pool{New: func() any {
+		b := make([]ObjectVersion, 0, opts.BatchSize)
+		return &b
+	}}
+	newBatch := func() []ObjectVersion {
+		return (*batchPool.Get().(*[]ObjectVersion))[:0]
+	}
+	var workers sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range batches {
+				// the buffer is put into the pool by address, which must
+				// not be that of the loop variable reused by the next job
+				job := job
+				if opts.Slots != nil {
+					opts.Slots <- struct{}{}
+				}
+				opts.Metrics.batchStarted()
+				deleteObjectVersions(ctx, results, client, limiter, throttle, &opts, job)
+				if opts.Slots != nil {
+					<-opts.Slots
+				}
+				if !opts.DryRun {
+					batchPool.Put(&job.objectVersions)
+				}
+			}
+		}()
+	}
+
+	// summary is owned by the listing loop, collected by the collector
+	// goroutine until collectorDone is closed
+	summary := Summary{
+		Skipped:  make(map[string]int),
+		Prefixes: make(map[string]PrefixSummary),
+	}
+	// the object versions skipped by the checks of a worker have already
+	// been counted as submitted; collected.Objects and the Objects
+	// of collected.Prefixes hold their negative number
+	collected := Summary{
+		Skipped:    make(map[string]int),
+		ErrorCodes: make(map[string]int),
+		Prefixes:   make(map[string]PrefixSummary),
+	}
+	var numProcessed atomic.Int64
+	collectorDone := make(chan struct{})
+
+	logger := opts.logger()
+	checkpoints := &checkpointer{bucket: opts.Bucket, resume: opts.Resume}
+	saveCheckpoints := opts.OnCheckpoint != nil && !opts.DryRun
+	go func() {
+		defer close(collectorDone)
+		lastLog := time.Now()
+		lastCheckpoint := time.Now()
+		for r := range results {
+			processed := int(numProcessed.Add(int64(r.BatchSize)))
+			opts.Metrics.batchDone(r, opts.DryRun)
+			collected.Errors += r.ErrorCount
+			collected.Retries += r.Retries
+			collected.Locked += r.LockedCount
+			collected.Bytes += r.Bytes
+			skipped := r.numSkipped()
+			collected.Objects -= skipped
+			for filter, n := range r.Skipped {
+				collected.Skipped[filter] += n
+			}
+			for code, n := range r.ErrorCodes {
+				collected.ErrorCodes[code] += n
+			}
+			p := collected.Prefixes[r.Prefix]
+			p.Objects -= skipped
+			p.Errors += r.ErrorCount
+			p.Bytes += r.Bytes
+			collected.Prefixes[r.Prefix] = p
+			if opts.FolderSummary {
+				if collected.Folders == nil {
+					collected.Folders = make(map[string]PrefixSummary)
+				}
+				addFolders(collected.Folders, r)
+			}
+			collected.Failures = append(collected.Failures, r.Failures...)
+			if r.Err != nil {
+				if opts.StopOnError {
+					cancel(fmt.Errorf("failed to delete objects: %w", r.Err))
+				} else {
+					logger.Warn("failed to delete batch", "objects", r.BatchSize, "error", r.Err)
+				}
+			} else if r.ErrorCount > 0 {
+				logger.Warn("some objects of a batch could not be deleted", "objects", r.BatchSize, "errors", r.ErrorCount)
+			}
+			if time.Since(lastLog) >= progressLogInterval {
+				lastLog = time.Now()
+				logger.Info("progress", "processed", processed, "errors", collected.Errors)
+			}
+			if opts.AuditLog != nil {
+				if err := writeAuditRecords(opts.AuditLog, r.Deleted); err != nil {
+					logger.Error("failed to write audit log", "error", err)
+				}
+			}
+			if opts.PlanOutput != nil && opts.DryRun {
+				if err := opts.PlanOutput.add(r.DryRun); err != nil {
+					logger.Error("failed to write plan", "error", err)
+				}
+			}
+			if saveCheckpoints && checkpoints.complete(r.position, r.Err == nil) &&
+				time.Since(lastCheckpoint) >= checkpointInterval {
+				lastCheckpoint = time.Now()
+				opts.OnCheckpoint(checkpoints.checkpoint())
+			}
+			opts.reportBatch(r, processed+collected.Objects, collected.Errors)
+			if bar != nil {
+				bar.update(processed, false)
+			}
+		}
+	}()
+
+	// mu guards summary, numSelected and listErr, which are updated by the
+	// listing goroutines
+	var mu sync.Mutex
+	numSelected := 0
+	var listErr error
+	setListErr := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if listErr == nil {
+			listErr = err
+		}
+	}
+	stopped := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return ctx.Err() != nil || listErr != nil || summary.Capped
+	}
+
+	submit := func(prefix string, batch []ObjectVersion, position batchPosition) bool {
+		select {
+		case batches <- deleteJob{prefix: prefix, objectVersions: batch, position: position}:
+		case <-ctx.Done():
+			return false
+		}
+		mu.Lock()
+		summary.Objects += len(batch)
+		p := summary.Prefixes[prefix]
+		p.Objects += len(batch)
+		summary.Prefixes[prefix] = p
+		mu.Unlock()
+		return true
+	}
+
+	// a page that keeps failing with ContinueOnListError is listed again in
+	// pages of a single entry, in case it fails for its size, e.g. by
+	// timing out
+	singleEntries := opts
+	singleEntries.MaxKeys = 1
+
+	// deleteRange lists the entries of prefix within r and submits them in
+	// batches; a partial batch is submitted at the end of the range
+	deleteRange := func(prefix string, r keyRange) {
+		marker := opts.resumeMarker(prefix, r)
+		rangeIndex := checkpoints.add(prefix, r, marker)
+		objectPager := newPager(client, &opts, prefix, keyRange{After: marker, Until: r.Until})
+		selector := newSelector(&opts)
+		// lastKey is the key of the last entry listed, doneKey the one
+		// before it, whose versions have all been listed
+		seq := 0
+		lastKey, lastVersion, doneKey := "", "", marker
+		// restart returns a pager continuing after the last entry listed
+		restart := func(o *Options) pager {
+			if lastKey == "" {
+				return newPager(client, o, prefix, keyRange{After: marker, Until: r.Until})
+			}
+			return resumePager(client, o, prefix, r, lastKey, lastVersion)
+		}
+		// singlePages is the number of single entry pages still to be
+		// listed after a failed page, which covers the failed page
+		singlePages := 0
+		batch := newBatch()
+		// take reports whether e is to be deleted
+		take := func(e listEntry) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			if listErr != nil || summary.Capped {
+				return false
+			}
+			if !opts.explicit() && prefix != "" && !strings.HasPrefix(e.Key, prefix) {
+				if opts.Strict {
+					listErr = fmt.Errorf("encountered object without requested prefix %s: %s", prefix, e.Key)
+					return false
+				}
+				logger.Warn("skipping listed object without requested prefix", "prefix", prefix, "key", e.Key)
+				summary.Skipped[SkippedByPrefixAnomaly]++
+				return false
+			}
+			if reason := selector.match(e); reason != "" {
+				summary.Skipped[reason]++
+				return false
+			}
+			if opts.MaxObjects > 0 && numSelected == opts.MaxObjects {
+				summary.Capped = true
+				return false
+			}
+			numSelected++
+			return true
+		}
+	listing:
+		for objectPager.HasMorePages() && !stopped() {
+			var entries []listEntry
+			var err error
+			// input entries are not listed, reading them again would
+			// skip the rest of the failed page
+			if opts.ContinueOnListError && !opts.explicit() {
+				entries, err = nextPageWithRetries(ctx, objectPager)
+			} else {
+				entries, err = objectPager.nextPage(ctx)
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				if !opts.ContinueOnListError || opts.explicit() {
+					setListErr(fmt.Errorf("failed to list objects: %w", err))
+					return
+				}
+				if singlePages == 0 {
+					logger.Warn("failed to list objects, listing the page again entry by entry", "prefix", prefix, "after", lastKey, "error", err)
+					singlePages = opts.maxKeys()
+					objectPager = restart(&singleEntries)
+					continue
+				}
+				// the next key cannot be listed, so neither can the
+				// remaining keys of the range; the listing continues
+				// with the next range
+				logger.Warn("failed to list objects, skipping the rest of the range", "prefix", prefix, "after", doneKey, "error", err)
+				mu.Lock()
+				summary.ListFailures = append(summary.ListFailures, ListFailure{
+					Prefix:  prefix,
+					After:   doneKey,
+					Until:   r.Until,
+					Message: err.Error(),
+				})
+				mu.Unlock()
+				break listing
+			}
+			for _, e := range entries {
+				if ctx.Err() != nil {
+					return
+				}
+				ok := take(e)
+				if stopped() {
+					break
+				}
+				if e.Key != lastKey {
+					if lastKey != "" {
+						doneKey = lastKey
+					}
+					lastKey = e.Key
+				}
+				lastVersion = e.VersionId
+				if !ok {
+					continue
+				}
+				logger.Debug("enqueued", "key", e.Key, "version_id", e.VersionId)
+				batch = append(batch, ObjectVersion{
+					Key:          e.Key,
+					VersionId:    e.VersionId,
+					Size:         e.Size,
+					LastModified: e.LastModified,
+					DeleteMarker: e.DeleteMarker,
+				})
+				if len(batch) == opts.BatchSize && submit(prefix, batch, batchPosition{rangeIndex, seq, doneKey}) {
+					batch = newBatch()
+					seq++
+				}
+			}
+			if singlePages > 0 {
+				singlePages--
+				if singlePages == 0 && objectPager.HasMorePages() {
+					// past the failed page
+					objectPager = restart(&opts)
+				}
+			}
+		}
+		mu.Lock()
+		failed := listErr != nil
+		mu.Unlock()
+		if len(batch) > 0 && ctx.Err() == nil && !failed {
+			if !objectPager.HasMorePages() && !stopped() {
+				// the range has been listed completely
+				doneKey = lastKey
+			}
+			submit(prefix, batch, batchPosition{rangeIndex, seq, doneKey})
+		}
+	}
+
+	if opts.explicit() {
+		deleteRange("", keyRange{})
+	} else {
+		for _, prefix := range opts.prefixes() {
+			if stopped() {
+				break
+			}
+			mu.Lock()
+			if _, ok := summary.Prefixes[prefix]; !ok {
+				summary.Prefixes[prefix] = PrefixSummary{}
+			}
+			mu.Unlock()
+			// the ranges of a prefix are listed concurrently
+			var listers sync.WaitGroup
+			for _, r := range opts.keyRanges(prefix) {
+				listers.Add(1)
+				go func(r keyRange) {
+					defer listers.Done()
+					deleteRange(prefix, r)
+				}(r)
+			}
+			listers.Wait()
+		}
+	}
+	close(batches)
+	workers.Wait()
+	close(results)
+	<-collectorDone
+	if saveCheckpoints {
+		opts.OnCheckpoint(checkpoints.checkpoint())
+	}
+	summary.Errors = collected.Errors
+	summary.Retries = collected.Retries
+	summary.Locked = collected.Locked
+	summary.Failures = collected.Failures
+	summary.ErrorCodes = collected.ErrorCodes
+	summary.Folders = collected.Folders
+	summary.Bytes = collected.Bytes
+	summary.Objects += collected.Objects
+	for filter, n := range collected.Skipped {
+		summary.Skipped[filter] += n
+	}
+	for prefix, c := range collected.Prefixes {
+		p := summary.Prefixes[prefix]
+		p.Objects += c.Objects
+		p.Errors = c.Errors
+		p.Bytes = c.Bytes
+		summary.Prefixes[prefix] = p
+	}
+	if bar != nil {
+		bar.finish(int(numProcessed.Load()))
+	}
+	if listErr != nil {
+		return summary, listErr
+	}
+	return summary, context.Cause(ctx)
+}
+
+// DeleteVersion deletes a single object version without listing the bucket.
+func DeleteVersion(ctx context.Context, client S3API, opts Options, key, versionId string) (Summary, error) {
+	if opts.Bucket == "" {
+		return Summary{}, errors.New("no bucket given")
+	}
+	if key == "" || versionId == "" {
+		return Summary{}, errors.New("key and version ID are required")
+	}
+	results := make(chan BatchResult, 1)
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	opts.Metrics.batchStarted()
+	deleteObjectVersions(ctx, results, client, limiter, nil, &opts, deleteJob{
+		objectVersions: []ObjectVersion{{Key: key, VersionId: versionId}},
+	})
+	r := <-results
+	opts.Metrics.batchDone(r, opts.DryRun)
+	if opts.AuditLog != nil {
+		if err := writeAuditRecords(opts.AuditLog, r.Deleted); err != nil {
+			opts.logger().Error("failed to write audit log", "error", err)
+		}
+	}
+	opts.reportBatch(r, r.BatchSize-r.numSkipped(), r.ErrorCount)
+	summary := Summary{
+		Objects:    r.BatchSize - r.numSkipped(),
+		Errors:     r.ErrorCount,
+		Retries:    r.Retries,
+		Locked:     r.LockedCount,
+		Failures:   r.Failures,
+		ErrorCodes: r.ErrorCodes,
+		Bytes:      r.Bytes,
+		Skipped:    make(map[string]int),
+	}
+	for filter, n := range r.Skipped {
+		summary.Skipped[filter] = n
+	}
+	if r.Err != nil {
+		return summary, fmt.Errorf("failed to delete object: %w", r.Err)
+	}
+	return summary, nil
+}
@@ -0,0 +1,1024 @@
+package rmdir
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// fakeEntry is an object version or delete marker listed by fakeS3.
+type fakeEntry struct {
+	key          string
+	versionId    string
+	size         int64
+	lastModified time.Time
+	marker       bool
+	latest       bool
+	storageClass string
+}
+
+// fakeS3 is an in-memory S3API serving canned listings. Deleted object
+// versions are recorded but stay listed, like in a bucket that is being
+// written to while the run proceeds.
+type fakeS3 struct {
+	// the other methods of S3API are not used by the tests and panic
+	S3API
+
+	mu sync.Mutex
+	// entries are sorted by key and, for every key, newest first
+	entries []fakeEntry
+	// failKey returns the error code of a key of a DeleteObjects request,
+	// or the empty string if the key is deleted. It may be nil.
+	failKey func(key string) string
+	// requestErr returns the error of the given DeleteObjects call,
+	// counting from 1, or nil. It may be nil.
+	requestErr func(call int) error
+	// listErr returns the error of a ListObjectVersions call returning
+	// the given keys, or nil. It may be nil.
+	listErr func(keys []string) error
+	// listDelay is the duration of every ListObjectVersions call.
+	listDelay time.Duration
+	// delay is the duration of every DeleteObjects call.
+	delay time.Duration
+	// calls holds the number of keys of every DeleteObjects call
+	calls   []int
+	deleted map[ObjectVersion]int
+}
+
+// newFakeS3 returns a fakeS3 listing the object versions of keys.
+func newFakeS3(keys ...string) *fakeS3 {
+	f := &fakeS3{deleted: make(map[ObjectVersion]int)}
+	for _, k := range keys {
+		f.add(fakeEntry{key: k, size: 1})
+	}
+	return f
+}
+
+// numberedKeys returns n keys below prefix in listing order.
+func numberedKeys(prefix string, n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%s%08d", prefix, i)
+	}
+	return keys
+}
+
+// add adds e as the oldest version of its key, with a version ID and a
+// modification time unless they are set.
+func (f *fakeS3) add(e fakeEntry) {
+	i := sort.Search(len(f.entries), func(i int) bool { return f.entries[i].key > e.key })
+	if e.versionId == "" {
+		e.versionId = fmt.Sprintf("v%d", len(f.entries))
+	}
+	if e.lastModified.IsZero() {
+		e.lastModified = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Add(-time.Duration(len(f.entries)) * time.Second)
+	}
+	e.latest = i == 0 || f.entries[i-1].key != e.key
+	f.entries = append(f.entries, fakeEntry{})
+	copy(f.entries[i+1:], f.entries[i:])
+	f.entries[i] = e
+}
+
+// start returns the index of the first entry after the markers.
+func (f *fakeS3) start(keyMarker, versionIdMarker string) int {
+	if keyMarker == "" {
+		return 0
+	}
+	i := sort.Search(len(f.entries), func(i int) bool { return f.entries[i].key >= keyMarker })
+	for ; i < len(f.entries) && f.entries[i].key == keyMarker; i++ {
+		if versionIdMarker != "" && f.entries[i].versionId == versionIdMarker {
+			return i + 1
+		}
+	}
+	return i
+}
+
+func (f *fakeS3) ListObjectVersions(ctx context.Context, in *s3.ListObjectVersionsInput, _ ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	if f.listDelay > 0 {
+		time.Sleep(f.listDelay)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prefix := aws.ToString(in.Prefix)
+	out := &s3.ListObjectVersionsOutput{}
+	var last fakeEntry
+	var keys []string
+	n := 0
+	for i := f.start(aws.ToString(in.KeyMarker), aws.ToString(in.VersionIdMarker)); i < len(f.entries); i++ {
+		e := f.entries[i]
+		if !strings.HasPrefix(e.key, prefix) {
+			continue
+		}
+		if n == int(aws.ToInt32(in.MaxKeys)) {
+			out.IsTruncated = aws.Bool(true)
+			out.NextKeyMarker = aws.String(last.key)
+			out.NextVersionIdMarker = aws.String(last.versionId)
+			break
+		}
+		if e.marker {
+			out.DeleteMarkers = append(out.DeleteMarkers, types.DeleteMarkerEntry{
+				Key:          aws.String(e.key),
+				VersionId:    aws.String(e.versionId),
+				LastModified: aws.Time(e.lastModified),
+				IsLatest:     aws.Bool(e.latest),
+			})
+		} else {
+			out.Versions = append(out.Versions, types.ObjectVersion{
+				Key:          aws.String(e.key),
+				VersionId:    aws.String(e.versionId),
+				LastModified: aws.Time(e.lastModified),
+				Size:         aws.Int64(e.size),
+				IsLatest:     aws.Bool(e.latest),
+				StorageClass: types.ObjectVersionStorageClass(e.storageClass),
+			})
+		}
+		last = e
+		keys = append(keys, e.key)
+		n++
+	}
+	if f.listErr != nil {
+		if err := f.listErr(keys); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeS3) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prefix := aws.ToString(in.Prefix)
+	after := aws.ToString(in.StartAfter)
+	if in.ContinuationToken != nil {
+		after = aws.ToString(in.ContinuationToken)
+	}
+	out := &s3.ListObjectsV2Output{}
+	n := 0
+	for i := f.start(after, ""); i < len(f.entries); i++ {
+		e := f.entries[i]
+		if !strings.HasPrefix(e.key, prefix) || !e.latest || e.marker {
+			continue
+		}
+		if n == int(aws.ToInt32(in.MaxKeys)) {
+			out.IsTruncated = aws.Bool(true)
+			out.NextContinuationToken = out.Contents[n-1].Key
+			break
+		}
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(e.key),
+			LastModified: aws.Time(e.lastModified),
+			Size:         aws.Int64(e.size),
+			StorageClass: types.ObjectStorageClass(e.storageClass),
+		})
+		n++
+	}
+	out.KeyCount = aws.Int32(int32(n))
+	return out, nil
+}
+
+func (f *fakeS3) DeleteObjects(ctx context.Context, in *s3.DeleteObjectsInput, _ ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	if f.delay > 0 {
+		time.Sleep(f.delay)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, len(in.Delete.Objects))
+	if f.requestErr != nil {
+		if err := f.requestErr(len(f.calls)); err != nil {
+			return nil, err
+		}
+	}
+	out := &s3.DeleteObjectsOutput{}
+	for _, o := range in.Delete.Objects {
+		if f.failKey != nil {
+			if code := f.failKey(aws.ToString(o.Key)); code != "" {
+				out.Errors = append(out.Errors, types.Error{
+					Key:       o.Key,
+					VersionId: o.VersionId,
+					Code:      aws.String(code),
+					Message:   aws.String("simulated " + code),
+				})
+				continue
+			}
+		}
+		f.deleted[ObjectVersion{Key: aws.ToString(o.Key), VersionId: aws.ToString(o.VersionId)}]++
+	}
+	return out, nil
+}
+
+// numCalls returns the number of DeleteObjects calls so far.
+func (f *fakeS3) numCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.calls)
+}
+
+// deletedKeys returns the keys of the deleted object versions in order.
+func (f *fakeS3) deletedKeys() []string {
+	keys := make([]string, 0, len(f.deleted))
+	for v := range f.deleted {
+		keys = append(keys, v.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// testOptions returns the options of a quiet run on the fake bucket.
+func testOptions() Options {
+	return Options{
+		Bucket:      "bucket",
+		BatchSize:   1000,
+		Concurrency: 4,
+		MaxKeys:     1000,
+		Quiet:       true,
+		Output:      io.Discard,
+		ErrOutput:   io.Discard,
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+// TestBatchBuffersAreNotShared deletes many small batches with several
+// workers, whose reused batch buffers must not alias a batch in flight.
+func TestBatchBuffersAreNotShared(t *testing.T) {
+	const n = 20000
+	client := newFakeS3(numberedKeys("", n)...)
+	for _, concurrency := range []int{4, 8} {
+		client.deleted = make(map[ObjectVersion]int)
+		opts := testOptions()
+		opts.BatchSize = 50
+		opts.Concurrency = concurrency
+		summary, err := DeleteVersions(context.Background(), client, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Objects != n || summary.Errors != 0 {
+			t.Errorf("concurrency %d: %d objects, %d errors, want %d objects", concurrency, summary.Objects, summary.Errors, n)
+		}
+		if len(client.deleted) != n {
+			t.Errorf("concurrency %d: %d distinct object versions deleted, want %d", concurrency, len(client.deleted), n)
+		}
+		for v, times := range client.deleted {
+			if times != 1 {
+				t.Errorf("concurrency %d: %s deleted %d times", concurrency, v.Key, times)
+			}
+		}
+	}
+}
+
+// BenchmarkDeleteVersions measures the allocations of the batch lifecycle on
+// a large prefix.
+func BenchmarkDeleteVersions(b *testing.B) {
+	client := newFakeS3(numberedKeys("", 200000)...)
+	opts := testOptions()
+	opts.Concurrency = 16
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := DeleteVersions(context.Background(), client, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDeleteVersions(t *testing.T) {
+	// the second DeleteObjects call is denied as a whole
+	secondDenied := func(call int) error {
+		if call == 2 {
+			return &smithy.GenericAPIError{Code: "AccessDenied", Message: "denied"}
+		}
+		return nil
+	}
+	tests := []struct {
+		name    string
+		entries []fakeEntry
+		// keys are added as single versions of one byte after entries
+		keys       []string
+		opts       func(*Options)
+		failKey    func(key string) string
+		requestErr func(call int) error
+		// deleted are the keys deleted in order, once each
+		deleted    []string
+		calls      int
+		objects    int
+		errors     int
+		errorCodes map[string]int
+		skipped    map[string]int
+		bytes      int64
+	}{
+		{
+			name:    "batches across pages",
+			keys:    numberedKeys("a/", 2500),
+			opts:    func(o *Options) { o.MaxKeys = 100; o.BatchSize = 300 },
+			deleted: numberedKeys("a/", 2500),
+			calls:   9,
+			objects: 2500,
+			bytes:   2500,
+		},
+		{
+			name:    "prefix",
+			keys:    []string{"a/1", "a/2", "ab", "b/1"},
+			opts:    func(o *Options) { o.Prefixes = []string{"a/"} },
+			deleted: []string{"a/1", "a/2"},
+			calls:   1,
+			objects: 2,
+			bytes:   2,
+		},
+		{
+			name: "size filter",
+			entries: []fakeEntry{
+				{key: "big", size: 100},
+				{key: "small", size: 10},
+			},
+			opts:    func(o *Options) { o.MinSize = 50 },
+			deleted: []string{"big"},
+			calls:   1,
+			objects: 1,
+			skipped: map[string]int{SkippedBySize: 1},
+			bytes:   100,
+		},
+		{
+			name: "older than",
+			entries: []fakeEntry{
+				{key: "new", lastModified: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+				{key: "old", lastModified: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)},
+			},
+			opts:    func(o *Options) { o.OlderThan = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) },
+			deleted: []string{"old"},
+			calls:   1,
+			objects: 1,
+			skipped: map[string]int{SkippedByDate: 1},
+		},
+		{
+			name: "markers only",
+			entries: []fakeEntry{
+				{key: "a", marker: true},
+				{key: "a", size: 1},
+				{key: "b", size: 1},
+			},
+			opts:    func(o *Options) { o.MarkersOnly = true },
+			deleted: []string{"a"},
+			calls:   1,
+			objects: 1,
+		},
+		{
+			name: "keep",
+			entries: []fakeEntry{
+				{key: "a", size: 1},
+				{key: "a", size: 2},
+				{key: "a", size: 4},
+				{key: "b", size: 8},
+			},
+			opts:    func(o *Options) { o.Keep = 1 },
+			deleted: []string{"a", "a"},
+			calls:   1,
+			objects: 2,
+			skipped: map[string]int{SkippedByKeep: 2},
+			bytes:   6,
+		},
+		{
+			name:    "exclude and glob",
+			keys:    []string{"logs/a.txt", "logs/archive/b.txt", "logs/c.gz", "tmp/d.txt"},
+			opts:    func(o *Options) { o.ExcludePrefixes = []string{"logs/archive/"}; o.Glob = mustGlob(t, "logs/**.txt") },
+			deleted: []string{"logs/a.txt"},
+			calls:   1,
+			objects: 1,
+			skipped: map[string]int{SkippedByExcludedPrefix: 1, SkippedByPattern: 2},
+			bytes:   1,
+		},
+		{
+			name:    "suffixes",
+			keys:    []string{"a.tmp", "b.log", "c.txt", "dir/d.tmp", "e.tmp.bak", "f.TMP", "tmp"},
+			opts:    func(o *Options) { o.Suffixes = []string{".tmp", ".log"} },
+			deleted: []string{"a.tmp", "b.log", "dir/d.tmp"},
+			calls:   1,
+			objects: 3,
+			skipped: map[string]int{SkippedBySuffix: 4},
+			bytes:   3,
+		},
+		{
+			name:    "suffixes under a prefix",
+			keys:    []string{"a.tmp", "dir/b.log", "dir/c.tmp", "dir/d.txt", "dirt/e.tmp"},
+			opts:    func(o *Options) { o.Prefixes = []string{"dir/"}; o.Suffixes = []string{".tmp"} },
+			deleted: []string{"dir/c.tmp"},
+			calls:   1,
+			objects: 1,
+			skipped: map[string]int{SkippedBySuffix: 2},
+			bytes:   1,
+		},
+		{
+			name: "key errors",
+			keys: []string{"a", "b", "c", "d"},
+			failKey: func(key string) string {
+				switch key {
+				case "b", "d":
+					return "AccessDenied"
+				case "c":
+					return "InvalidArgument"
+				}
+				return ""
+			},
+			deleted:    []string{"a"},
+			calls:      1,
+			objects:    4,
+			errors:     3,
+			errorCodes: map[string]int{"AccessDenied": 2, "InvalidArgument": 1},
+			bytes:      1,
+		},
+		{
+			name: "transient key errors are retried",
+			keys: []string{"a", "b"},
+			opts: func(o *Options) { o.MaxRetries = 1 },
+			failKey: func() func(string) string {
+				failed := false
+				return func(key string) string {
+					if key == "b" && !failed {
+						failed = true
+						return "InternalError"
+					}
+					return ""
+				}
+			}(),
+			deleted: []string{"a", "b"},
+			calls:   2,
+			objects: 2,
+			bytes:   2,
+		},
+		{
+			name:       "request errors",
+			keys:       numberedKeys("", 5),
+			opts:       func(o *Options) { o.BatchSize = 2; o.Concurrency = 1 },
+			requestErr: secondDenied,
+			deleted:    []string{"00000000", "00000001", "00000004"},
+			calls:      3,
+			objects:    5,
+			errors:     2,
+			errorCodes: map[string]int{"AccessDenied": 2},
+			bytes:      3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeS3()
+			for _, e := range tt.entries {
+				client.add(e)
+			}
+			for _, k := range tt.keys {
+				client.add(fakeEntry{key: k, size: 1})
+			}
+			client.failKey = tt.failKey
+			client.requestErr = tt.requestErr
+			opts := testOptions()
+			if tt.opts != nil {
+				tt.opts(&opts)
+			}
+			summary, err := DeleteVersions(context.Background(), client, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var deleted []string
+			for v, n := range client.deleted {
+				for i := 0; i < n; i++ {
+					deleted = append(deleted, v.Key)
+				}
+			}
+			sort.Strings(deleted)
+			if fmt.Sprint(deleted) != fmt.Sprint(tt.deleted) {
+				t.Errorf("deleted %v, want %v", deleted, tt.deleted)
+			}
+			if len(client.calls) != tt.calls {
+				t.Errorf("%d DeleteObjects calls, want %d", len(client.calls), tt.calls)
+			}
+			if summary.Objects != tt.objects || summary.Errors != tt.errors || summary.Bytes != tt.bytes {
+				t.Errorf("%d objects, %d errors, %d bytes, want %d, %d, %d",
+					summary.Objects, summary.Errors, summary.Bytes, tt.objects, tt.errors, tt.bytes)
+			}
+			if len(summary.Failures) != tt.errors {
+				t.Errorf("%d failures, want %d", len(summary.Failures), tt.errors)
+			}
+			if fmt.Sprint(summary.ErrorCodes) != fmt.Sprint(nonEmpty(tt.errorCodes)) {
+				t.Errorf("error codes %v, want %v", summary.ErrorCodes, tt.errorCodes)
+			}
+			if fmt.Sprint(summary.Skipped) != fmt.Sprint(nonEmpty(tt.skipped)) {
+				t.Errorf("skipped %v, want %v", summary.Skipped, tt.skipped)
+			}
+		})
+	}
+}
+
+func mustGlob(t *testing.T, pattern string) *regexp.Regexp {
+	t.Helper()
+	re, err := CompileGlob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return re
+}
+
+// nonEmpty returns m, or an empty map if m is nil, as the summary never
+// holds nil maps.
+func nonEmpty(m map[string]int) map[string]int {
+	if m == nil {
+		return map[string]int{}
+	}
+	return m
+}
+
+// TestTotalsOfManySmallBatches checks the accounting of the collector with
+// thousands of batches completing concurrently. Run it with -race.
+func TestTotalsOfManySmallBatches(t *testing.T) {
+	const n = 5000
+	client := newFakeS3(numberedKeys("p/", n)...)
+	client.failKey = func(key string) string {
+		if strings.HasSuffix(key, "7") {
+			return "AccessDenied"
+		}
+		return ""
+	}
+	opts := testOptions()
+	opts.Prefixes = []string{"p/"}
+	opts.BatchSize = 2
+	opts.Concurrency = 16
+	opts.Metrics = &Metrics{}
+	// OnBatch is called from the collector only, so it needs no lock
+	batches, reported := 0, 0
+	opts.OnBatch = func(r BatchResult) {
+		batches++
+		reported += r.BatchSize
+	}
+	summary, err := DeleteVersions(context.Background(), client, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const failed = n / 10
+	if summary.Objects != n || summary.Errors != failed || summary.Bytes != n-failed {
+		t.Errorf("%d objects, %d errors, %d bytes, want %d, %d, %d", summary.Objects, summary.Errors, summary.Bytes, n, failed, n-failed)
+	}
+	if p := summary.Prefixes["p/"]; p.Objects != n || p.Errors != failed || p.Bytes != n-failed {
+		t.Errorf("prefix totals %+v", p)
+	}
+	if batches != n/2 || reported != n {
+		t.Errorf("%d batches of %d objects reported, want %d of %d", batches, reported, n/2, n)
+	}
+	s := opts.Metrics.Snapshot()
+	if s.Deleted != n-failed || s.Errors != failed || s.Batches != n/2 || s.InFlight != 0 {
+		t.Errorf("metrics %+v", s)
+	}
+	if len(client.deleted) != n-failed {
+		t.Errorf("%d object versions deleted, want %d", len(client.deleted), n-failed)
+	}
+}
+
+func TestDeleteVersionMetrics(t *testing.T) {
+	client := newFakeS3("a", "b")
+	opts := testOptions()
+	opts.Metrics = &Metrics{}
+	if _, err := DeleteVersion(context.Background(), client, opts, "a", "v0"); err != nil {
+		t.Fatal(err)
+	}
+	s := opts.Metrics.Snapshot()
+	if s.Deleted != 1 || s.Requests != 1 || s.Batches != 1 || s.InFlight != 0 || s.PeakInFlight != 1 {
+		t.Errorf("metrics %+v", s)
+	}
+}
+
+// TestSaturatedResults stalls the collector until every worker waits to hand
+// over a result, then checks that the run still completes.
+func TestSaturatedResults(t *testing.T) {
+	const concurrency = 4
+	client := newFakeS3(numberedKeys("", 1000)...)
+	opts := testOptions()
+	opts.BatchSize = 10
+	opts.Concurrency = concurrency
+	// the batch held by the collector, a full buffer and a result per
+	// worker waiting to be sent
+	const saturated = 1 + 2*concurrency
+	stalled := make(chan struct{})
+	opts.OnBatch = func(BatchResult) {
+		select {
+		case <-stalled:
+			return
+		default:
+		}
+		defer close(stalled)
+		deadline := time.Now().Add(5 * time.Second)
+		for client.numCalls() < saturated {
+			if time.Now().After(deadline) {
+				t.Errorf("%d DeleteObjects calls with the collector stalled, want %d", client.numCalls(), saturated)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		// no worker can send another request while the results are full
+		time.Sleep(50 * time.Millisecond)
+		if n := client.numCalls(); n != saturated {
+			t.Errorf("%d DeleteObjects calls with the collector stalled, want %d", n, saturated)
+		}
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := DeleteVersions(context.Background(), client, opts)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("DeleteVersions did not return after the collector resumed")
+	}
+	if n := len(client.deletedKeys()); n != 1000 {
+		t.Errorf("%d keys deleted, want 1000", n)
+	}
+}
+
+// TestBatchLargerThanARequest checks that a batch beyond the key limit of
+// DeleteObjects is sent in several requests whose failures are merged into
+// the result of the batch.
+func TestBatchLargerThanARequest(t *testing.T) {
+	client := newFakeS3(numberedKeys("", 2500)...)
+	client.failKey = func(key string) string {
+		switch key {
+		case "00000005", "00000999":
+			return "AccessDenied"
+		case "00002499":
+			return "InvalidObjectState"
+		}
+		return ""
+	}
+	opts := testOptions()
+	opts.BatchSize = 2500
+	opts.Concurrency = 1
+	var results []BatchResult
+	opts.OnBatch = func(r BatchResult) { results = append(results, r) }
+	summary, err := DeleteVersions(context.Background(), client, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(client.calls) != "[1000 1000 500]" {
+		t.Errorf("DeleteObjects calls of %v keys, want [1000 1000 500]", client.calls)
+	}
+	if len(results) != 1 {
+		t.Fatalf("%d batches, want 1", len(results))
+	}
+	r := results[0]
+	if r.BatchSize != 2500 || r.ErrorCount != 3 {
+		t.Errorf("batch of %d with %d errors, want 2500 with 3", r.BatchSize, r.ErrorCount)
+	}
+	var failed []string
+	for _, f := range r.Failures {
+		failed = append(failed, f.Key+":"+f.Code)
+	}
+	sort.Strings(failed)
+	want := "[00000005:AccessDenied 00000999:AccessDenied 00002499:InvalidObjectState]"
+	if fmt.Sprint(failed) != want {
+		t.Errorf("failures %v, want %v", failed, want)
+	}
+	if fmt.Sprint(r.ErrorCodes) != "map[AccessDenied:2 InvalidObjectState:1]" {
+		t.Errorf("error codes of the batch %v", r.ErrorCodes)
+	}
+	if summary.Objects != 2500 || summary.Errors != 3 || len(summary.Failures) != 3 || summary.Bytes != 2497 {
+		t.Errorf("%d objects, %d errors, %d failures, %d bytes, want 2500, 3, 3, 2497",
+			summary.Objects, summary.Errors, len(summary.Failures), summary.Bytes)
+	}
+	if fmt.Sprint(summary.ErrorCodes) != fmt.Sprint(r.ErrorCodes) {
+		t.Errorf("error codes %v, want %v", summary.ErrorCodes, r.ErrorCodes)
+	}
+	if n := len(client.deletedKeys()); n != 2497 {
+		t.Errorf("%d keys deleted, want 2497", n)
+	}
+}
+
+// TestInputWithAttributeFilters checks that the filters input records have
+// no attributes for are rejected instead of passing or skipping everything.
+func TestInputWithAttributeFilters(t *testing.T) {
+	filters := map[string]func(*Options){
+		"older than":        func(o *Options) { o.OlderThan = time.Now() },
+		"newer than":        func(o *Options) { o.NewerThan = time.Now().Add(-time.Hour) },
+		"written before":    func(o *Options) { o.WrittenBefore = time.Now() },
+		"min age":           func(o *Options) { o.MinAge = time.Hour },
+		"min size":          func(o *Options) { o.MinSize = 1 },
+		"max size":          func(o *Options) { o.MaxSize = 1 },
+		"storage classes":   func(o *Options) { o.StorageClasses = []string{"STANDARD"} },
+		"markers only":      func(o *Options) { o.MarkersOnly = true },
+		"no delete markers": func(o *Options) { o.NoDeleteMarkers = true },
+		"empty folders":     func(o *Options) { o.EmptyFolders = true },
+	}
+	for name, filter := range filters {
+		t.Run(name, func(t *testing.T) {
+			client := newFakeS3()
+			opts := testOptions()
+			opts.Input = strings.NewReader("a\n")
+			filter(&opts)
+			if _, err := DeleteVersions(context.Background(), client, opts); err == nil {
+				t.Error("no error")
+			}
+			if client.numCalls() != 0 {
+				t.Error("input entries deleted")
+			}
+		})
+	}
+}
+
+func TestInputWithPrefixes(t *testing.T) {
+	client := newFakeS3()
+	opts := testOptions()
+	opts.Input = strings.NewReader("a/1\nb/2\n")
+	opts.Prefixes = []string{"a/"}
+	if _, err := DeleteVersions(context.Background(), client, opts); err == nil {
+		t.Error("prefixes of input entries not rejected")
+	}
+	if client.numCalls() != 0 {
+		t.Error("input entries deleted")
+	}
+}
+
+// payerRecorder records the RequestPayer of the version listings.
+type payerRecorder struct {
+	*fakeS3
+	payers []types.RequestPayer
+}
+
+func (p *payerRecorder) ListObjectVersions(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	p.payers = append(p.payers, params.RequestPayer)
+	return p.fakeS3.ListObjectVersions(ctx, params, optFns...)
+}
+
+func (p *payerRecorder) DeleteBucket(ctx context.Context, params *s3.DeleteBucketInput, optFns ...func(*s3.Options)) (*s3.DeleteBucketOutput, error) {
+	return &s3.DeleteBucketOutput{}, nil
+}
+
+func TestRequestPayerOfVersionListing(t *testing.T) {
+	client := &payerRecorder{fakeS3: newFakeS3(numberedKeys("", 5)...)}
+	opts := testOptions()
+	opts.MaxKeys = 2
+	opts.RequestPayer = true
+	if _, err := DeleteVersions(context.Background(), client, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.payers) != 3 {
+		t.Fatalf("%d list requests, want 3", len(client.payers))
+	}
+	// the emptiness check before the bucket is deleted
+	client.entries = nil
+	if err := DeleteBucket(context.Background(), client, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.payers) != 4 {
+		t.Fatalf("%d list requests, want 4", len(client.payers))
+	}
+	for i, payer := range client.payers {
+		if payer != types.RequestPayerRequester {
+			t.Errorf("list request %d with request payer %q", i+1, payer)
+		}
+	}
+}
+
+func TestContinueOnListError(t *testing.T) {
+	errList := &smithy.GenericAPIError{Code: "InternalError", Message: "list failed"}
+	tests := []struct {
+		name    string
+		listErr func(keys []string) error
+		deleted int
+		// after is the After of the list failure, if any
+		after []string
+	}{
+		{
+			name: "page too large",
+			listErr: func(keys []string) error {
+				if len(keys) > 1 && slices.Contains(keys, "00000005") {
+					return errList
+				}
+				return nil
+			},
+			deleted: 10,
+		},
+		{
+			name: "entry cannot be listed",
+			listErr: func(keys []string) error {
+				if slices.Contains(keys, "00000005") {
+					return errList
+				}
+				return nil
+			},
+			deleted: 5,
+			// the versions of the key before the failed entry might not
+			// all have been listed
+			after: []string{"00000003"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeS3(numberedKeys("", 10)...)
+			client.listErr = tt.listErr
+			opts := testOptions()
+			opts.MaxKeys = 4
+			opts.ContinueOnListError = true
+			// the count sees the same listing as the deletion
+			c, err := Tally(context.Background(), client, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Versions != tt.deleted || len(c.ListFailures) != len(tt.after) {
+				t.Errorf("counted %d versions with %d list failures, want %d with %d", c.Versions, len(c.ListFailures), tt.deleted, len(tt.after))
+			}
+			summary, err := DeleteVersions(context.Background(), client, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted := client.deletedKeys(); len(deleted) != tt.deleted || deleted[0] != "00000000" {
+				t.Errorf("deleted %v, want the first %d keys", deleted, tt.deleted)
+			}
+			if summary.Objects != tt.deleted {
+				t.Errorf("%d objects submitted, want %d", summary.Objects, tt.deleted)
+			}
+			var after []string
+			for _, f := range summary.ListFailures {
+				after = append(after, f.After)
+			}
+			if fmt.Sprint(after) != fmt.Sprint(tt.after) {
+				t.Errorf("list failures after %v, want %v", after, tt.after)
+			}
+		})
+	}
+}
+
+// TestTallyResume checks that a count of a resumed run skips the keys of
+// every range that the checkpoint records as processed.
+func TestTallyResume(t *testing.T) {
+	client := newFakeS3(numberedKeys("", 10)...)
+	opts := testOptions()
+	opts.Shards = []string{"00000004"}
+	opts.Resume = &Checkpoint{
+		Bucket: "bucket",
+		Positions: []Position{
+			{Prefix: "", After: "", KeyMarker: "00000001"},
+			{Prefix: "", After: "00000004", KeyMarker: "00000006"},
+		},
+	}
+	c, err := Tally(context.Background(), client, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 00000002 to 00000004 and 00000007 to 00000009
+	if c.Keys != 6 || c.Versions != 6 {
+		t.Errorf("counted %d keys, %d versions, want 6 of each", c.Keys, c.Versions)
+	}
+	opts.SamplePages = 1
+	opts.MaxKeys = 2
+	if c, err := Tally(context.Background(), client, opts); err != nil || !c.Sampled || c.Estimated != nil {
+		t.Errorf("sampled a resumed run: %+v, %v, want no estimate", c, err)
+	}
+}
+
+// TestMalformedInputLine checks that a malformed line in the middle of a
+// large input fails the run, also with ContinueOnListError.
+func TestMalformedInputLine(t *testing.T) {
+	var input strings.Builder
+	for i := 1; i <= 3000; i++ {
+		if i == 1500 {
+			input.WriteString(",v1\n")
+			continue
+		}
+		fmt.Fprintf(&input, "%08d,v%d\n", i, i)
+	}
+	for _, continueOnListError := range []bool{false, true} {
+		client := newFakeS3()
+		opts := testOptions()
+		opts.Input = strings.NewReader(input.String())
+		opts.ContinueOnListError = continueOnListError
+		summary, err := DeleteVersions(context.Background(), client, opts)
+		if err == nil || !strings.Contains(err.Error(), "input line 1500") {
+			t.Errorf("continue on list error %t: error %v, want one of input line 1500", continueOnListError, err)
+		}
+		if n := len(client.deletedKeys()); n > 1000 || len(summary.ListFailures) > 0 {
+			t.Errorf("continue on list error %t: %d keys deleted and %d list failures", continueOnListError, n, len(summary.ListFailures))
+		}
+	}
+}
+
+// TestDefaultMaxKeys checks that the listings of default options request
+// full pages.
+func TestDefaultMaxKeys(t *testing.T) {
+	client := newFakeS3(numberedKeys("", 2500)...)
+	opts := testOptions()
+	opts.MaxKeys = 0
+	count, err := Tally(context.Background(), client, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.Versions != 2500 {
+		t.Errorf("%d versions counted, want 2500", count.Versions)
+	}
+	if _, err := DeleteVersions(context.Background(), client, opts); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(client.deletedKeys()); n != 2500 {
+		t.Errorf("%d keys deleted, want 2500", n)
+	}
+	opts.NoVersions = true
+	if count, err = Tally(context.Background(), client, opts); err != nil || count.Versions != 2500 {
+		t.Errorf("%d current objects counted, want 2500 (%v)", count.Versions, err)
+	}
+}
+
+// BenchmarkShards compares the listing of keys starting with evenly spread
+// hex digits by a single paginator and by one per hex digit, with a delay
+// of 1ms per list request.
+func BenchmarkShards(b *testing.B) {
+	keys := make([]string, 20000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%x%07d", i%16, i)
+	}
+	sort.Strings(keys)
+	for _, shards := range [][]string{nil, strings.Split("1,2,3,4,5,6,7,8,9,a,b,c,d,e,f", ",")} {
+		b.Run(fmt.Sprintf("ranges=%d", len(shards)+1), func(b *testing.B) {
+			client := newFakeS3(keys...)
+			client.listDelay = time.Millisecond
+			opts := testOptions()
+			opts.MaxKeys = 100
+			opts.Concurrency = 16
+			opts.Shards = shards
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := DeleteVersions(context.Background(), client, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSampleEstimate(t *testing.T) {
+	hexKeys := make([]string, 10000)
+	for i := range hexKeys {
+		hexKeys[i] = fmt.Sprintf("p/%04x.log", i*6)
+	}
+	tests := []struct {
+		name string
+		keys []string
+		opts func(*Options)
+	}{
+		{"decimal keys", numberedKeys("p/", 10000), nil},
+		{"hexadecimal keys", hexKeys, nil},
+		{"current objects", numberedKeys("p/", 10000), func(o *Options) { o.NoVersions = true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeS3(tt.keys...)
+			opts := testOptions()
+			opts.Prefixes = []string{"p/"}
+			opts.MaxKeys = 100
+			opts.SamplePages = 10
+			if tt.opts != nil {
+				tt.opts(&opts)
+			}
+			c, err := Tally(context.Background(), client, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !c.Sampled || c.Versions != 1000 || c.Estimated == nil {
+				t.Fatalf("count %+v, want a sample of 1000 versions with an estimate", c)
+			}
+			if e := c.Estimated; e.Versions < 9500 || e.Versions > 10500 || e.Bytes < 9500 || e.Share < 0.095 || e.Share > 0.105 {
+				t.Errorf("estimate %+v, want about 10000 versions and bytes and a share of 0.1", *e)
+			}
+		})
+	}
+	client := newFakeS3(numberedKeys("p/", 500)...)
+	opts := testOptions()
+	opts.MaxKeys = 100
+	opts.SamplePages = 10
+	if c, err := Tally(context.Background(), client, opts); err != nil || c.Sampled || c.Estimated != nil {
+		t.Errorf("count %+v (%v) of a complete listing, want no estimate", c, err)
+	}
+}
+
+func TestDirectoryBucketOptions(t *testing.T) {
+	options := map[string]func(*Options){
+		"start after key":        func(o *Options) { o.StartAfterKey = "a" },
+		"continue on list error": func(o *Options) { o.ContinueOnListError = true },
+		"request payer":          func(o *Options) { o.RequestPayer = true },
+	}
+	for name, option := range options {
+		opts := testOptions()
+		opts.Bucket = "bucket--use1-az4--x-s3"
+		opts.DirectoryBucket = true
+		option(&opts)
+		if _, err := DeleteVersions(context.Background(), newFakeS3("a", "b"), opts); err == nil {
+			t.Errorf("%s not rejected for a directory bucket", name)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package rmdir
+
+import (
+	"fmt"
+	"sort"
+)
+
+// keyRange selects the keys k with After < k <= Until. An empty bound is
+// open.
+type keyRange struct {
+	After string
+	Until string
+}
+
+// keyRanges splits the keys below prefix at opts.Shards. Every boundary
+// belongs to the range that ends with it, so the ranges cover the keyspace
+// without gaps and overlaps.
+func (opts *Options) keyRanges(prefix string) []keyRange {
+	ranges := make([]keyRange, 0, len(opts.Shards)+1)
+	after := ""
+	for _, shard := range opts.Shards {
+		ranges = append(ranges, keyRange{After: after, Until: prefix + shard})
+		after = prefix + shard
+	}
+	return append(ranges, keyRange{After: after})
+}
+
+// truncate drops the entries after the end of r, which are listed in key
+// order. It reports whether the end of r has been reached.
+func (r keyRange) truncate(entries []listEntry) ([]listEntry, bool) {
+	if r.Until == "" {
+		return entries, false
+	}
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].Key > r.Until })
+	return entries[:i], i < len(entries)
+}
+
+func validateShards(shards []string) error {
+	for i, shard := range shards {
+		if shard == "" {
+			return fmt.Errorf("empty shard boundary")
+		}
+		if i > 0 && shard <= shards[i-1] {
+			return fmt.Errorf("shard boundaries not in ascending order: %s, %s", shards[i-1], shard)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package rmdir
+
+import "math/rand"
+
+// simulatedErrorCode is the error code of the failures of
+// Options.SimulateErrorRate.
+const simulatedErrorCode = "SimulatedError"
+
+// simulateErrors fails a random share rate of objectVersions. It returns the
+// remaining object versions and the failures.
+func simulateErrors(objectVersions []ObjectVersion, rate float64) ([]ObjectVersion, []Failure) {
+	var kept []ObjectVersion
+	var failures []Failure
+	for _, v := range objectVersions {
+		if rand.Float64() >= rate {
+			kept = append(kept, v)
+			continue
+		}
+		failures = append(failures, Failure{
+			Key:       v.Key,
+			VersionId: v.VersionId,
+			Code:      simulatedErrorCode,
+			Message:   "simulated error of a dry run",
+		})
+	}
+	return kept, failures
+}
@@ -0,0 +1,73 @@
+package rmdir
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// tagLookupConcurrency is the maximum number of concurrent GetObjectTagging
+// requests of a worker.
+const tagLookupConcurrency = 8
+
+// filterTags returns the object versions carrying all of opts.Tags and the
+// number of versions left out. Delete markers have no tags and are left out.
+// Versions whose tags cannot be fetched are left out as well, since it is
+// unknown whether they match.
+func filterTags(ctx context.Context, client S3API, opts *Options, objectVersions []ObjectVersion) ([]ObjectVersion, int) {
+	match := make([]bool, len(objectVersions))
+	sem := make(chan struct{}, tagLookupConcurrency)
+	var wg sync.WaitGroup
+	for i, v := range objectVersions {
+		if v.DeleteMarker {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, v ObjectVersion) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			match[i] = hasTags(ctx, client, opts, v)
+		}(i, v)
+	}
+	wg.Wait()
+	matching := make([]ObjectVersion, 0, len(objectVersions))
+	for i, v := range objectVersions {
+		if match[i] {
+			matching = append(matching, v)
+		}
+	}
+	return matching, len(objectVersions) - len(matching)
+}
+
+// hasTags reports whether v carries all of opts.Tags.
+func hasTags(ctx context.Context, client S3API, opts *Options, v ObjectVersion) bool {
+	var versionId *string
+	if v.VersionId != "" {
+		versionId = aws.String(v.VersionId)
+	}
+	out, err := client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket:       aws.String(opts.Bucket),
+		Key:          aws.String(v.Key),
+		VersionId:    versionId,
+		RequestPayer: opts.requestPayer(),
+	})
+	if err != nil {
+		opts.logger().Warn("failed to get object tags, skipping", "key", v.Key, "version_id", v.VersionId, "error", err)
+		return false
+	}
+	tags := make(map[string]string, len(out.TagSet))
+	for _, t := range out.TagSet {
+		tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	for k, want := range opts.Tags {
+		if got, ok := tags[k]; !ok || got != want {
+			return false
+		}
+	}
+	return true
+}
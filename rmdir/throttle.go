@@ -0,0 +1,88 @@
+package rmdir
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// throttleCooldown is the minimum time between two decreases of the
+// concurrency limit. The responses to the requests in flight when S3 starts
+// to throttle all report throttling, but call for a single decrease.
+const throttleCooldown = time.Second
+
+// throttle limits the number of concurrent DeleteObjects requests of a run
+// with additive increase and multiplicative decrease: the limit is halved
+// whenever S3 answers with SlowDown and grows by about one for every limit
+// requests that succeed, up to max. Without throttling the limit stays at
+// max, the number of workers. A nil *throttle does not limit anything.
+type throttle struct {
+	mu       sync.Mutex
+	max      int
+	limit    float64
+	inFlight int
+	// changed is closed and replaced whenever a request finishes or the
+	// limit changes
+	changed      chan struct{}
+	lastDecrease time.Time
+	metrics      *Metrics
+}
+
+func newThrottle(concurrency int, metrics *Metrics) *throttle {
+	metrics.setConcurrencyLimit(concurrency)
+	return &throttle{max: concurrency, limit: float64(concurrency), changed: make(chan struct{}), metrics: metrics}
+}
+
+// acquire waits until a request may be sent.
+func (t *throttle) acquire(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	for {
+		t.mu.Lock()
+		if t.inFlight < int(t.limit) {
+			t.inFlight++
+			t.mu.Unlock()
+			return nil
+		}
+		changed := t.changed
+		t.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release records the outcome of a request sent after acquire.
+func (t *throttle) release(throttled bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight--
+	switch {
+	case throttled:
+		t.metrics.throttled()
+		if time.Since(t.lastDecrease) >= throttleCooldown {
+			t.lastDecrease = time.Now()
+			t.limit = max(1, t.limit/2)
+		}
+	case t.limit < float64(t.max):
+		t.limit = min(float64(t.max), t.limit+1/t.limit)
+	}
+	t.metrics.setConcurrencyLimit(int(t.limit))
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// isThrottling reports whether a failed request was throttled by S3.
+func isThrottling(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "SlowDown"
+}
@@ -1,144 +1,850 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
-	"math"
+	"log/slog"
 	"os"
+	"os/signal"
+	"regexp"
+	"slices"
 	"strings"
-	"sync"
+	"syscall"
+	"time"
 
+	"github.com/1001R/s3rmdir/rmdir"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
-type objectVersion struct {
-	Key       string
-	VersionId string
-}
+// Exit codes besides 0 for success and 1 for usage and fatal errors.
+const (
+	exitDeleteErrors = 2
+	exitNoSuchBucket = 3
+	exitAccessDenied = 4
+	exitWrongRegion  = 5
+	exitTimeout      = 124
+	exitInterrupted  = 130
+)
 
-type deleteBatchResult struct {
-	BatchSize  int
-	ErrorCount int
-}
+// requestBytesPerObject estimates the bytes a DeleteObjects request sends per
+// object: the XML of its key and version ID. -limit-bandwidth is converted to
+// a rate with it, since deletions cost requests rather than bytes of
+// object data.
+const requestBytesPerObject = 256
 
-func deleteObjectVersions(
-	resultChannel chan deleteBatchResult,
-	client *s3.Client,
-	bucket string,
-	objectVersions []objectVersion,
-) {
-	deleteParam := &types.Delete{
-		Objects: make([]types.ObjectIdentifier, 0, len(objectVersions)),
-		Quiet:   true,
-	}
-	for _, v := range objectVersions {
-		deleteParam.Objects = append(deleteParam.Objects, types.ObjectIdentifier{
-			Key:       aws.String(v.Key),
-			VersionId: aws.String(v.VersionId),
-		})
-	}
-	params := s3.DeleteObjectsInput{
-		Bucket: aws.String(bucket),
-		Delete: deleteParam,
-	}
-	result, err := client.DeleteObjects(context.TODO(), &params)
-	if err != nil {
-		log.Fatalf("failed to delete objects: %v", err)
-	}
-	resultChannel <- deleteBatchResult{
-		BatchSize:  len(objectVersions),
-		ErrorCount: len(result.Errors),
-	}
-}
+// maxBatchSize bounds -batch. Every worker holds a batch in memory, and
+// batches beyond a few thousand objects only delay the reports.
+const maxBatchSize = 100000
+
+// maxConcurrency bounds -concurrency. Every worker holds a batch, a slot of
+// the results buffer and usually an HTTP connection.
+const maxConcurrency = 1024
+
+// maxRetries bounds -max-retries. With backoffs of up to 20s, a batch is
+// retried for up to half an hour before it counts as failed.
+const maxRetries = 100
 
 func main() {
-	fPrefix := flag.String("prefix", "", "`prefix`/folder to delete")
-	fBucket := flag.String("bucket", "", "`bucket` to delete from (required)")
-	fBatchSize := flag.Uint("batch", 1000, "batch size")
-	fRegion := flag.String("region", "eu-west-1", "AWS `region`")
+	var fPrefixes stringList
+	flag.Var(&fPrefixes, "prefix", "`prefix`/folder to delete (repeatable)")
+	fBucket := flag.String("bucket", "", "`bucket` name or access point ARN to delete from (required)")
+	fBatchSize := flag.Uint("batch", 1000, fmt.Sprintf("number of objects per delete batch; larger batches are sent in requests of %d", rmdir.MaxDeleteKeys))
+	fRegion := flag.String("region", "", "AWS `region` (defaults to $AWS_REGION or the region of the profile)")
+	fConcurrency := flag.Uint("concurrency", 16, "maximum number of concurrent delete requests")
+	fDryRun := flag.Bool("dry-run", false, "list the objects that would be deleted without deleting them")
+	fStopOnError := flag.Bool("stop-on-error", false, "abort as soon as a delete request fails")
+	fMaxRetries := flag.Uint("max-retries", 5, "maximum number of retries of a failed delete request")
+	fEndpoint := flag.String("endpoint", os.Getenv("AWS_ENDPOINT_URL"), "custom S3 endpoint `url`, e.g. for MinIO (defaults to $AWS_ENDPOINT_URL)")
+	fProfile := flag.String("profile", "", "shared config `profile` to use for credentials")
+	fPathStyle := flag.Bool("path-style", false, "use path-style addressing instead of virtual-hosted buckets")
+	fOutput := flag.String("output", "text", "output `format`: text or json")
+	fProgress := flag.Bool("progress", false, "show the number of deleted objects and the rate on stderr")
+	fPrecount := flag.Bool("precount", false, "count the objects before deleting, so that -progress shows a progress bar with ETA (lists the prefix twice)")
+	fOlderThan := flag.String("older-than", "", "only delete versions last modified before this `time` (RFC 3339 timestamp or duration like 720h)")
+	fMinSize := flag.String("min-size", "", "only delete versions of at least this `size`, e.g. 10MB")
+	fMaxSize := flag.String("max-size", "", "only delete versions of at most this `size`, e.g. 1GiB")
+	fSizeIncludeMarkers := flag.Bool("size-include-markers", false, "delete markers even if a size filter is active")
+	var fSuffixes stringList
+	flag.Var(&fSuffixes, "suffix", "only delete keys ending in `suffix` (repeatable)")
+	fNewerThan := flag.String("newer-than", "", "only delete versions last modified after this `time` (RFC 3339 timestamp or duration like 720h)")
+	fInclude := flag.String("include", "", "only delete keys matching this regular `expression`")
+	fExclude := flag.String("exclude", "", "never delete keys matching this regular `expression`")
+	var fExcludePrefixes stringList
+	flag.Var(&fExcludePrefixes, "exclude-prefix", "never delete keys starting with `prefix`, e.g. logs/archive/ (repeatable)")
+	fGlob := flag.String("glob", "", "only delete keys matching this glob `pattern`, where ** also matches across \"/\"")
+	fMarkersOnly := flag.Bool("markers-only", false, "only delete delete markers, restoring the most recent version of deleted objects")
+	fKeep := flag.Int("keep", 0, "keep the `n` most recent versions of every key")
+	fLatestOnly := flag.Bool("latest-only", false, "only delete the current version of every key by its version ID, so that the previous version becomes current again")
+	fBypassGovernance := flag.Bool("bypass-governance", false, "delete objects locked in governance mode")
+	fRequestPayer := flag.Bool("request-payer", false, "acknowledge the charges of a Requester Pays bucket")
+	fForce := flag.Bool("force", false, "delete without asking for confirmation")
+	fErrorLog := flag.String("error-log", "", "write the objects that could not be deleted as CSV to `file`")
+	fRate := flag.Float64("rate", 0, "maximum number of objects deleted per second (0 for no limit)")
+	fLimitBandwidth := flag.String("limit-bandwidth", "", "cap the upload of delete requests at this `size` per second, e.g. 100KB; deleting sends a few hundred bytes per object regardless of its size, so this works as a lower -rate")
+	fMaxObjects := flag.Int("max-objects", 0, "stop after deleting `n` objects (0 for no limit)")
+	fNoVersions := flag.Bool("no-versions", false, "list with ListObjectsV2 and delete without version IDs, for unversioned buckets")
+	fInputFile := flag.String("input-file", "", "delete the keys listed in `file` (- for stdin) as CSV lines key or key,versionId instead of listing the bucket")
+	fAutoRegion := flag.Bool("auto-region", false, "detect the region of the bucket and use it instead of -region, also for the -config targets without a region")
+	fDeleteBucket := flag.Bool("delete-bucket", false, "delete the bucket itself once it is empty")
+	fShards := flag.String("shards", "", "list the keyspace in parallel, split at the comma-separated `boundaries` (or hex for keys starting with a hex digit)")
+	fTimeout := flag.Duration("timeout", 0, "stop submitting deletions after this `duration` and exit with code 124 (0 for no timeout)")
+	fKey := flag.String("key", "", "delete only this `key`, requires -version-id")
+	fVersionId := flag.String("version-id", "", "`version` of -key to delete")
+	fLogLevel := flag.String("log-level", "info", "log `level`: debug, info, warn or error")
+	fLogFormat := flag.String("log-format", "text", "log `format`: text or json")
+	fRoleARN := flag.String("role-arn", "", "assume the role with this `arn` for cross-account access")
+	fRoleSessionName := flag.String("role-session-name", "s3rmdir", "session `name` of the assumed role")
+	fMetricsAddr := flag.String("metrics-addr", "", "serve Prometheus metrics at /metrics on this `address`, e.g. :9090")
+	fMaxKeys := flag.Uint("max-keys", 1000, "number of keys per list request (1-1000)")
+	fNonRecursive := flag.Bool("non-recursive", false, "only delete the keys directly below the prefix, not those in subfolders")
+	fDelimiter := flag.String("delimiter", "/", "`delimiter` separating the folders with -non-recursive")
+	fCount := flag.Bool("count", false, "only count the keys, versions and bytes that would be deleted")
+	fCheckpointFile := flag.String("checkpoint-file", "", "record the progress in `file` every few seconds")
+	fResume := flag.Bool("resume", false, "skip the keys recorded as processed in -checkpoint-file")
+	fInsecureSkipVerify := flag.Bool("insecure-skip-verify", false, "do not verify the TLS certificate of the endpoint, e.g. a self-signed one (insecure)")
+	fHTTPTimeout := flag.Duration("http-timeout", 0, "`timeout` of a single HTTP request, e.g. 2m (0 for no timeout)")
+	fMaxIdleConns := flag.Int("max-idle-conns", 100, "maximum number of idle HTTP connections")
+	fMaxConnsPerHost := flag.Int("max-conns-per-host", 0, "maximum number of HTTP connections to the endpoint (0 for no limit)")
+	var fStorageClasses stringList
+	flag.Var(&fStorageClasses, "storage-class", "only delete versions in this storage `class`, e.g. GLACIER (repeatable)")
+	fNoncurrentOlderThan := flag.String("noncurrent-older-than", "", "only delete versions that became noncurrent before this `time` (RFC 3339 timestamp or duration like 720h), keeping current versions")
+	fSample := flag.Int("sample", 0, "only count like -count, but list at most `n` pages per prefix and estimate the totals from the share of the key space listed, assuming the keys are spread evenly")
+	fContinueOnListError := flag.Bool("continue-on-list-error", false, "retry failing list requests, then list their page entry by entry, and if that fails too skip the rest of the key range up to the next -shards boundary or the end of the prefix instead of aborting")
+	fAuditLog := flag.String("audit-log", "", "append a JSON line for every deleted object version to `file`")
+	fUseDualStack := flag.Bool("use-dualstack", false, "use the dual-stack (IPv4 and IPv6) S3 endpoint")
+	fUseFIPS := flag.Bool("use-fips", false, "use the FIPS 140-2 validated S3 endpoint")
+	fVersion := flag.Bool("version", false, "print the version and exit")
+	fQuiet := flag.Bool("quiet", false, "only print the final summary")
+	fVerbose := flag.Bool("verbose", false, "print every deleted object version and log the list markers of every page")
+	var fTags stringList
+	flag.Var(&fTags, "tag", "only delete objects carrying the tag `key=value` (repeatable, one extra request per object)")
+	fExcludeNewerThanStart := flag.Bool("exclude-newer-than-start", false, "never delete versions last modified after the run started")
+	fAll := flag.Bool("all", false, "permit deleting from the root of the bucket when no -prefix or an empty one is given")
+	fDirectoryBucket := flag.Bool("directory-bucket", false, "treat the bucket as an S3 Express One Zone directory bucket (detected from the --x-s3 suffix of its name)")
+	fPlanFile := flag.String("plan-file", "", "write the object versions a dry run would delete to `file` as a JSON plan")
+	fFromPlan := flag.String("from-plan", "", "delete exactly the object versions of the plan `file` written by -plan-file instead of listing the bucket")
+	fStats := flag.Bool("stats", false, "print throughput figures at the end of the run, e.g. to tune -batch, -concurrency and -rate")
+	fDiffPlan := flag.String("diff-plan", "", "list the bucket like a dry run and compare the result with the plan `file` written by -plan-file")
+	fColor := flag.String("color", "auto", "color the summary: `mode` auto (if stdout is a terminal), always or never")
+	fWebhookURL := flag.String("webhook-url", "", "POST a JSON notification with the outcome of the run to `url` when it ends")
+	fConfig := flag.String("config", "", "delete from the targets of the YAML `file` concurrently instead of -bucket and -prefix")
+	fMinAge := flag.Duration("min-age", 0, "never delete versions younger than this `duration`, e.g. 1h as a safety net on active prefixes")
+	fSummaryByPrefix := flag.Bool("summary-by-prefix", false, "break the summary down by the first level of folders below each prefix")
+	fStrict := flag.Bool("strict", false, "abort if S3 lists a key outside of the requested prefix instead of skipping it")
+	fSummaryFile := flag.String("summary-file", "", "write a JSON report of the run to `file` when it ends, also after a failure or interruption")
+	fOwner := flag.String("owner", "", "only delete versions owned by this canonical user `id`; S3 only lists owners to callers allowed to read the object ACLs")
+	fRetryFailedFromLog := flag.String("retry-failed-from-log", "", "delete only the object versions recorded as failed in the -error-log `file` of a previous run")
+	fBatchTimeout := flag.Duration("batch-timeout", 0, "give up on a delete request after this `duration` and retry it, reported as BatchTimeout if it keeps timing out (0 for no timeout)")
+	fAccessKey := flag.String("access-key", "", "AWS access key `id`, requires -secret-key; prefer the environment or a profile, flags are visible in the process list")
+	fSecretKey := flag.String("secret-key", "", "AWS secret access `key`, requires -access-key")
+	fSessionToken := flag.String("session-token", "", "session `token` of temporary credentials given with -access-key and -secret-key")
+	fEmptyFolders := flag.Bool("empty-folders", false, "only delete the zero-byte folder placeholder objects whose keys end in \"/\", keeping all other objects")
+	// a testing aid, see hiddenFlags
+	fSimulateErrorRate := flag.Float64("simulate-error-rate", 0, "fail this `share` of the objects of a dry run at random, between 0 and 1")
+	fBucketPattern := flag.String("bucket-pattern", "", "delete from every bucket of the account whose name matches this regular `expression`, e.g. ^ci-; requires -i-know-what-im-doing")
+	fIKnowWhatImDoing := flag.Bool("i-know-what-im-doing", false, "permit -bucket-pattern")
+	fNoDeleteMarkers := flag.Bool("no-delete-markers", false, "keep all delete markers and only delete object versions, so that deleted objects stay deleted instead of reappearing")
+	fStartAfterKey := flag.String("start-after-key", "", "only list the keys after `key` in lexicographic order, e.g. to resume a run by hand")
+	fStartAfterVersion := flag.String("start-after-version", "", "start after this `version` of -start-after-key instead of after all of its versions")
+	fSkipLocked := flag.Bool("skip-locked", false, "check the object lock status of every object and skip locked ones (up to two extra requests per object)")
 
+	flag.Usage = usage
 	flag.Parse()
 
-	prefix := strings.Trim(*fPrefix, "/")
-	if prefix != "" {
-		prefix += "/"
+	if *fVersion {
+		fmt.Println(versionString())
+		return
 	}
-	if *fBucket == "" {
-		flag.Usage()
+
+	logger, err := newLogger(*fLogLevel, *fLogFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	if *fBatchSize > math.MaxInt {
-		log.Fatal("illegal batch size")
+	slog.SetDefault(logger)
+	if useColor, err = colorEnabled(*fColor); err != nil {
+		fatal(err.Error())
 	}
-	batchSize := int(*fBatchSize)
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(*fRegion),
-	)
+	prefixes := make([]string, 0, len(fPrefixes))
+	for _, p := range fPrefixes {
+		prefixes = append(prefixes, folderPrefix(p))
+	}
+	if *fBucket == "" && *fConfig == "" && *fBucketPattern == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+	// -config and -bucket-pattern delete from several targets with runTargets
+	multiTarget := *fConfig != "" || *fBucketPattern != ""
+	if multiTarget {
+		if *fKey != "" || *fInputFile != "" || *fFromPlan != "" || *fPlanFile != "" || *fDiffPlan != "" ||
+			*fCheckpointFile != "" || *fCount || *fSample > 0 || *fDeleteBucket || *fProgress ||
+			*fMetricsAddr != "" || *fAuditLog != "" || *fDirectoryBucket || *fSummaryFile != "" || *fRetryFailedFromLog != "" ||
+			*fErrorLog != "" || *fStats {
+			fatal("-config and -bucket-pattern do not support -key, -input-file, -from-plan, -plan-file, -diff-plan, -checkpoint-file, -count, -sample, -delete-bucket, -progress, -metrics-addr, -audit-log, -directory-bucket, -summary-file, -retry-failed-from-log, -error-log and -stats")
+		}
+	}
+	var targets []target
+	if *fConfig != "" {
+		if *fBucket != "" || len(fPrefixes) > 0 || *fBucketPattern != "" {
+			fatal("-config cannot be combined with -bucket, -prefix or -bucket-pattern")
+		}
+		if !*fForce && !*fDryRun {
+			fatal("-config runs without confirmation, use -force or -dry-run")
+		}
+		if targets, err = readTargets(*fConfig, *fAll); err != nil {
+			fatal("illegal -config", "error", err)
+		}
+	}
+	var bucketPattern *regexp.Regexp
+	if *fBucketPattern != "" {
+		if *fBucket != "" {
+			fatal("-bucket-pattern cannot be combined with -bucket")
+		}
+		if !*fIKnowWhatImDoing {
+			fatal("-bucket-pattern deletes from every matching bucket of the account; pass -i-know-what-im-doing to proceed")
+		}
+		if bucketPattern, err = regexp.Compile(*fBucketPattern); err != nil {
+			fatal("illegal -bucket-pattern", "error", err)
+		}
+	}
+	// -key and -input-file name the objects to delete, -count, -sample and
+	// -dry-run do not delete, and -delete-bucket asks for the whole bucket
+	if (len(prefixes) == 0 || slices.Contains(prefixes, "")) && !*fAll && *fConfig == "" &&
+		*fKey == "" && *fInputFile == "" && *fFromPlan == "" && *fRetryFailedFromLog == "" && *fDiffPlan == "" && !*fCount && *fSample == 0 && !*fDryRun && !*fDeleteBucket {
+		fatal("no -prefix given, which would delete every object version in the bucket; pass -all to proceed")
+	}
+	bucketIsARN, err := isBucketARN(*fBucket)
 	if err != nil {
-		log.Fatalf("unable to load SDK config, %v", err)
+		fatal("illegal -bucket", "error", err)
 	}
-
-	results := make(chan deleteBatchResult, 1000)
-	var waitGroup sync.WaitGroup
-
-	s3Client := s3.NewFromConfig(cfg)
-	listObjectVersionsParams := s3.ListObjectVersionsInput{
-		Bucket: fBucket,
-		Prefix: aws.String(prefix),
+	if bucketIsARN && *fPathStyle {
+		fatal("-path-style cannot be combined with an access point ARN")
+	}
+	if bucketIsARN && *fDeleteBucket {
+		fatal("-delete-bucket cannot be combined with an access point ARN")
+	}
+	directoryBucket := *fDirectoryBucket || rmdir.IsDirectoryBucket(*fBucket)
+	if directoryBucket {
+		if bucketIsARN {
+			fatal("-directory-bucket cannot be combined with an access point ARN")
+		}
+		// directory buckets are only reachable through their zonal endpoint
+		if *fPathStyle || *fEndpoint != "" || *fUseDualStack || *fUseFIPS {
+			fatal("directory buckets cannot be combined with -path-style, -endpoint, -use-dualstack or -use-fips")
+		}
+		if *fMarkersOnly || *fKeep > 0 || *fNoncurrentOlderThan != "" || *fShards != "" ||
+			*fCheckpointFile != "" || *fSkipLocked || len(fTags) > 0 {
+			fatal("-markers-only, -keep, -noncurrent-older-than, -shards, -checkpoint-file, -skip-locked and -tag are not supported for directory buckets")
+		}
+	}
+	if *fBatchSize == 0 || *fBatchSize > maxBatchSize {
+		fatal("illegal batch size", "batch", *fBatchSize, "min", 1, "max", maxBatchSize)
+	}
+	if *fConcurrency == 0 || *fConcurrency > maxConcurrency {
+		fatal("illegal concurrency", "concurrency", *fConcurrency, "min", 1, "max", maxConcurrency)
+	}
+	if *fRate < 0 {
+		fatal("illegal rate")
+	}
+	if *fSimulateErrorRate < 0 || *fSimulateErrorRate > 1 {
+		fatal("illegal -simulate-error-rate, must be between 0 and 1")
+	}
+	if *fSimulateErrorRate > 0 && !*fDryRun {
+		fatal("-simulate-error-rate requires -dry-run")
+	}
+	bandwidth, err := parseSize(*fLimitBandwidth)
+	if err != nil {
+		fatal("illegal -limit-bandwidth", "error", err)
+	}
+	if bandwidth > 0 {
+		// the workers share the limiter, so -concurrency only bounds the
+		// requests waiting for it
+		limit := float64(bandwidth) / requestBytesPerObject
+		if *fRate == 0 || limit < *fRate {
+			*fRate = limit
+		}
+		slog.Info("effective rate", "objects_per_second", *fRate, "bytes_per_object", requestBytesPerObject)
+	}
+	if *fMaxObjects < 0 {
+		fatal("illegal maximum number of objects")
+	}
+	if *fKeep < 0 {
+		fatal("illegal number of versions to keep")
+	}
+	if *fMaxRetries > maxRetries {
+		fatal("illegal number of retries", "max-retries", *fMaxRetries, "max", maxRetries)
+	}
+	if (*fUseFIPS || *fUseDualStack) && *fEndpoint != "" {
+		fatal("-use-fips and -use-dualstack select an AWS endpoint and cannot be combined with -endpoint or $AWS_ENDPOINT_URL")
+	}
+	if *fMinAge < 0 {
+		fatal("illegal -min-age")
+	}
+	if *fBatchTimeout < 0 {
+		fatal("illegal -batch-timeout")
+	}
+	if (*fAccessKey == "") != (*fSecretKey == "") {
+		fatal("-access-key and -secret-key must be given together")
+	}
+	if *fSessionToken != "" && *fAccessKey == "" {
+		fatal("-session-token requires -access-key and -secret-key")
+	}
+	if *fAccessKey != "" && *fProfile != "" {
+		fatal("-access-key cannot be combined with -profile")
+	}
+	if *fHTTPTimeout < 0 {
+		fatal("illegal -http-timeout")
+	}
+	if *fMaxIdleConns < 0 || *fMaxConnsPerHost < 0 {
+		fatal("illegal number of HTTP connections")
+	}
+	if *fMaxConnsPerHost > 0 && uint(*fMaxConnsPerHost) < *fConcurrency {
+		slog.Warn("-max-conns-per-host is lower than -concurrency, delete requests will wait for connections")
+	}
+	if *fMaxKeys < 1 || *fMaxKeys > 1000 {
+		fatal("illegal -max-keys, must be between 1 and 1000")
+	}
+	if *fPrecount && !*fProgress {
+		fatal("-precount requires -progress")
+	}
+	if *fQuiet && *fVerbose {
+		fatal("-quiet cannot be combined with -verbose")
+	}
+	format := rmdir.Format(*fOutput)
+	if format != rmdir.FormatText && format != rmdir.FormatJSON {
+		fatal("illegal output format", "format", *fOutput)
+	}
+	start := time.Now()
+	olderThan, err := parseTime(*fOlderThan, start)
+	if err != nil {
+		fatal("illegal -older-than", "error", err)
+	}
+	newerThan, err := parseTime(*fNewerThan, start)
+	if err != nil {
+		fatal("illegal -newer-than", "error", err)
+	}
+	noncurrentOlderThan, err := parseTime(*fNoncurrentOlderThan, start)
+	if err != nil {
+		fatal("illegal -noncurrent-older-than", "error", err)
+	}
+	if *fLatestOnly && (*fMarkersOnly || *fNoVersions || *fKeep > 0 || *fNoncurrentOlderThan != "" || *fInputFile != "" || *fFromPlan != "") {
+		fatal("-latest-only cannot be combined with -markers-only, -no-versions, -keep, -noncurrent-older-than, -input-file or -from-plan")
+	}
+	if *fOwner != "" && (*fInputFile != "" || *fFromPlan != "" || *fKey != "") {
+		fatal("-owner cannot be combined with -input-file, -from-plan or -key")
+	}
+	if *fNoDeleteMarkers && (*fMarkersOnly || *fNoVersions || directoryBucket) {
+		fatal("-no-delete-markers cannot be combined with -markers-only, -no-versions or directory buckets")
+	}
+	if *fStartAfterVersion != "" && *fStartAfterKey == "" {
+		fatal("-start-after-version requires -start-after-key")
+	}
+	if *fStartAfterVersion != "" && (*fNoVersions || directoryBucket) {
+		fatal("-start-after-version cannot be combined with -no-versions or directory buckets")
+	}
+	if directoryBucket && (*fStartAfterKey != "" || *fContinueOnListError || *fRequestPayer) {
+		fatal("-start-after-key, -continue-on-list-error and -request-payer are not supported for directory buckets")
+	}
+	if (*fInputFile != "" || *fRetryFailedFromLog != "") && (*fOlderThan != "" || *fNewerThan != "" || *fExcludeNewerThanStart ||
+		*fMinAge > 0 || *fMinSize != "" || *fMaxSize != "" || len(fStorageClasses) > 0 ||
+		*fMarkersOnly || *fNoDeleteMarkers || *fEmptyFolders) {
+		fatal("-input-file and -retry-failed-from-log name keys without dates, sizes, storage classes or delete marker flags and cannot be combined with -older-than, -newer-than, -exclude-newer-than-start, -min-age, -min-size, -max-size, -storage-class, -markers-only, -no-delete-markers or -empty-folders")
+	}
+	if *fFromPlan != "" && len(fStorageClasses) > 0 {
+		fatal("-from-plan cannot be combined with -storage-class, plans have no storage classes")
+	}
+	if *fStartAfterKey != "" && (*fInputFile != "" || *fFromPlan != "" || *fRetryFailedFromLog != "" || *fKey != "") {
+		fatal("-start-after-key cannot be combined with -input-file, -from-plan, -retry-failed-from-log or -key")
+	}
+	if *fStartAfterKey != "" && len(prefixes) > 0 && !slices.ContainsFunc(prefixes, func(p string) bool {
+		return strings.HasPrefix(*fStartAfterKey, p)
+	}) {
+		slog.Warn("-start-after-key is outside of every -prefix, so each prefix is either listed in full or not at all", "key", *fStartAfterKey)
+	}
+	if !noncurrentOlderThan.IsZero() && (*fMarkersOnly || *fNoVersions || *fInputFile != "") {
+		fatal("-noncurrent-older-than cannot be combined with -markers-only, -no-versions or -input-file")
+	}
+	minSize, err := parseSize(*fMinSize)
+	if err != nil {
+		fatal("illegal -min-size", "error", err)
+	}
+	maxSize, err := parseSize(*fMaxSize)
+	if err != nil {
+		fatal("illegal -max-size", "error", err)
+	}
+	storageClasses := make([]string, 0, len(fStorageClasses))
+	for _, c := range fStorageClasses {
+		storageClasses = append(storageClasses, strings.ToUpper(c))
+	}
+	tags := make(map[string]string, len(fTags))
+	for _, t := range fTags {
+		k, v, ok := strings.Cut(t, "=")
+		if !ok || k == "" {
+			fatal("illegal -tag, expected key=value", "tag", t)
+		}
+		tags[k] = v
+	}
+	var include, exclude *regexp.Regexp
+	if *fInclude != "" {
+		if include, err = regexp.Compile(*fInclude); err != nil {
+			fatal("illegal -include", "error", err)
+		}
+	}
+	if *fExclude != "" {
+		if exclude, err = regexp.Compile(*fExclude); err != nil {
+			fatal("illegal -exclude", "error", err)
+		}
+	}
+	var glob *regexp.Regexp
+	if *fGlob != "" {
+		if glob, err = rmdir.CompileGlob(*fGlob); err != nil {
+			fatal("illegal -glob", "error", err)
+		}
 	}
-	objectPaginator := s3.NewListObjectVersionsPaginator(s3Client, &listObjectVersionsParams)
-	batch := make([]objectVersion, 0, batchSize)
 
-	numObjects := 0
+	if (*fKey == "") != (*fVersionId == "") {
+		fatal("-key and -version-id must be given together")
+	}
+	if *fSample < 0 {
+		fatal("illegal -sample")
+	}
+	if *fSample > 0 {
+		*fCount = true
+	}
+	if *fCount && *fKey != "" {
+		fatal("-count cannot be combined with -key")
+	}
+	// -diff-plan only lists, like -count and -dry-run
+	if *fInputFile == "-" && !*fForce && !*fDryRun && !*fCount && *fDiffPlan == "" {
+		fatal("refusing to delete without confirmation: keys are read from stdin, use -force")
+	}
+	if !*fForce && !*fDryRun && !*fCount && *fDiffPlan == "" && !isTerminal(os.Stdin) {
+		fatal("refusing to delete without confirmation: stdin is not a terminal, use -force")
+	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	go func() {
-		numProcessed := 0
-		numErrors := 0
-		for r := range results {
-			numProcessed += r.BatchSize
-			numErrors += r.ErrorCount
-			fmt.Printf("%d objects deleted, %d errors\n", numProcessed, numErrors)
-			waitGroup.Done()
-		}
+		<-ctx.Done()
+		// restore default signal handling so that a second signal terminates immediately
+		stop()
+		fmt.Fprintln(os.Stderr, "interrupted, waiting for in-flight batches (press Ctrl-C again to force exit)")
 	}()
 
-	for objectPaginator.HasMorePages() {
-		page, err := objectPaginator.NextPage(context.TODO())
+	configOptions := []func(*config.LoadOptions) error{
+		config.WithHTTPClient(newHTTPClient(httpOptions{
+			InsecureSkipVerify: *fInsecureSkipVerify,
+			Timeout:            *fHTTPTimeout,
+			MaxIdleConns:       *fMaxIdleConns,
+			MaxConnsPerHost:    *fMaxConnsPerHost,
+			Concurrency:        int(*fConcurrency),
+		})),
+	}
+	if *fInsecureSkipVerify {
+		slog.Warn("TLS certificate verification is disabled, use -insecure-skip-verify only with test endpoints")
+	}
+	if *fRegion != "" {
+		configOptions = append(configOptions, config.WithRegion(*fRegion))
+	}
+	if *fProfile != "" {
+		configOptions = append(configOptions, config.WithSharedConfigProfile(*fProfile))
+	}
+	if *fAccessKey != "" {
+		slog.Warn("credentials given on the command line are visible to other users in the process list, prefer the environment or a profile")
+		configOptions = append(configOptions, config.WithCredentialsProvider(staticCredentials(*fAccessKey, *fSecretKey, *fSessionToken)))
+	}
+	if *fUseDualStack {
+		configOptions = append(configOptions, config.WithUseDualStackEndpoint(aws.DualStackEndpointStateEnabled))
+	}
+	if *fUseFIPS {
+		configOptions = append(configOptions, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, configOptions...)
+	if err != nil {
+		fatal("unable to load SDK config", "error", err)
+	}
+	if cfg.Region == "" {
+		if !*fAutoRegion {
+			fatal("no region given, use -region, $AWS_REGION, a profile region or -auto-region")
+		}
+		// GetBucketLocation answers from any region
+		cfg.Region = "us-east-1"
+	}
+	if *fRoleARN != "" {
+		if err := assumeRole(ctx, &cfg, *fRoleARN, *fRoleSessionName); err != nil {
+			fatal("unable to assume role", "role", *fRoleARN, "error", err)
+		}
+	}
+
+	clientOptions := func(o *s3.Options) {
+		if *fEndpoint != "" {
+			o.BaseEndpoint = aws.String(*fEndpoint)
+		}
+		o.UsePathStyle = *fPathStyle
+		// requests to an access point are sent to the region in its ARN
+		o.UseARNRegion = bucketIsARN
+	}
+	s3Client := s3.NewFromConfig(cfg, clientOptions)
+	if *fAutoRegion && !bucketIsARN && !directoryBucket && !multiTarget {
+		region, err := bucketRegion(ctx, s3Client, *fBucket)
 		if err != nil {
-			log.Fatalf("failed to list objects: %v", err)
+			slog.Warn("failed to detect the region of the bucket", "bucket", *fBucket, "region", cfg.Region, "error", err)
+		} else if region != cfg.Region {
+			slog.Info("detected the region of the bucket", "bucket", *fBucket, "region", region)
+			s3Client = s3.NewFromConfig(cfg, clientOptions, func(o *s3.Options) {
+				o.Region = region
+			})
+		}
+	}
+	if *fAutoRegion && *fConfig != "" {
+		detectRegions(ctx, s3Client, targets)
+	}
+	if bucketPattern != nil {
+		if targets, err = matchBuckets(ctx, s3Client, bucketPattern, prefixes); err != nil {
+			fatal("failed to list the buckets", "error", err)
+		}
+		if len(targets) == 0 {
+			fatal("no bucket matches -bucket-pattern", "pattern", *fBucketPattern)
+		}
+		if !*fForce && !*fDryRun && !confirmTargets(targets, *fBucketPattern) {
+			fmt.Fprintln(os.Stderr, "aborted")
+			return
+		}
+	}
+	if !multiTarget {
+		if msg, code := checkBucket(ctx, s3Client, *fBucket); code != 0 {
+			slog.Error(msg)
+			os.Exit(code)
+		}
+		if !bucketIsARN && !directoryBucket && *fInputFile == "" && *fRetryFailedFromLog == "" && *fKey == "" {
+			warnVersioning(ctx, s3Client, *fBucket, *fNoVersions)
 		}
-		deleteVersion := func(key, versionId string) {
-			if prefix != "" && !strings.HasPrefix(key, prefix) {
-				log.Fatalf("encountered object without requested prefix: %s", key)
+	}
+	opts := rmdir.Options{
+		Bucket:              *fBucket,
+		Prefixes:            prefixes,
+		BatchSize:           int(*fBatchSize),
+		Concurrency:         int(*fConcurrency),
+		DryRun:              *fDryRun,
+		SimulateErrorRate:   *fSimulateErrorRate,
+		StopOnError:         *fStopOnError,
+		MaxRetries:          int(*fMaxRetries),
+		BatchTimeout:        *fBatchTimeout,
+		Format:              format,
+		Progress:            *fProgress,
+		Precount:            *fPrecount,
+		OlderThan:           olderThan,
+		NewerThan:           newerThan,
+		MinSize:             minSize,
+		MaxSize:             maxSize,
+		SizeFilterMarkers:   *fSizeIncludeMarkers,
+		Suffixes:            fSuffixes,
+		Include:             include,
+		Exclude:             exclude,
+		Glob:                glob,
+		ExcludePrefixes:     fExcludePrefixes,
+		MarkersOnly:         *fMarkersOnly,
+		NoDeleteMarkers:     *fNoDeleteMarkers,
+		EmptyFolders:        *fEmptyFolders,
+		LatestOnly:          *fLatestOnly,
+		MinAge:              *fMinAge,
+		FolderSummary:       *fSummaryByPrefix,
+		Strict:              *fStrict,
+		Keep:                *fKeep,
+		BypassGovernance:    *fBypassGovernance,
+		RequestPayer:        *fRequestPayer,
+		Rate:                *fRate,
+		MaxObjects:          *fMaxObjects,
+		NoVersions:          *fNoVersions,
+		DirectoryBucket:     directoryBucket,
+		Shards:              parseShards(*fShards),
+		StartAfterKey:       *fStartAfterKey,
+		StartAfterVersion:   *fStartAfterVersion,
+		SkipLocked:          *fSkipLocked,
+		MaxKeys:             int(*fMaxKeys),
+		SamplePages:         *fSample,
+		Quiet:               *fQuiet,
+		Verbose:             *fVerbose,
+		ContinueOnListError: *fContinueOnListError,
+		StorageClasses:      storageClasses,
+		Tags:                tags,
+		Owner:               *fOwner,
+		NoncurrentOlderThan: noncurrentOlderThan,
+	}
+	if *fExcludeNewerThanStart {
+		opts.WrittenBefore = start
+	}
+	if isFlagSet("delimiter") && !*fNonRecursive {
+		fatal("-delimiter requires -non-recursive")
+	}
+	if *fNonRecursive {
+		if *fDelimiter == "" {
+			fatal("-non-recursive requires a -delimiter")
+		}
+		opts.Delimiter = *fDelimiter
+	}
+	if *fSkipLocked {
+		slog.Warn("-skip-locked sends up to two additional requests per object, which slows down the run and is billed")
+	}
+	if len(tags) > 0 {
+		slog.Warn("-tag sends an additional request per object, which slows down the run and is billed")
+	}
+	if targets != nil {
+		runCtx, cancel := ctx, context.CancelFunc(func() {})
+		if *fTimeout > 0 {
+			runCtx, cancel = context.WithTimeout(ctx, *fTimeout)
+		}
+		code := runTargets(runCtx, cfg, clientOptions, opts, targets, int(*fConcurrency), *fWebhookURL)
+		cancel()
+		os.Exit(code)
+	}
+	if *fPlanFile != "" && !*fDryRun {
+		fatal("-plan-file requires -dry-run")
+	}
+	if *fFromPlan != "" && (*fInputFile != "" || *fKey != "" || len(fPrefixes) > 0) {
+		fatal("-from-plan cannot be combined with -input-file, -key or -prefix")
+	}
+	if len(fPrefixes) > 0 && (*fInputFile != "" || *fRetryFailedFromLog != "") {
+		fatal("-prefix cannot be combined with -input-file or -retry-failed-from-log, which name the keys to delete")
+	}
+	if *fDiffPlan != "" && (*fFromPlan != "" || *fPlanFile != "" || *fKey != "" || *fCount) {
+		fatal("-diff-plan cannot be combined with -from-plan, -plan-file, -key or -count")
+	}
+	if *fRetryFailedFromLog != "" && (*fInputFile != "" || *fFromPlan != "" || *fKey != "" || *fCheckpointFile != "" || *fPrecount) {
+		fatal("-retry-failed-from-log cannot be combined with -input-file, -from-plan, -key, -checkpoint-file or -precount")
+	}
+	if *fResume && *fCheckpointFile == "" {
+		fatal("-resume requires -checkpoint-file")
+	}
+	if *fCheckpointFile != "" {
+		if *fInputFile != "" || *fFromPlan != "" {
+			fatal("-checkpoint-file cannot be combined with -input-file or -from-plan")
+		}
+		if *fResume {
+			cp, err := readCheckpoint(*fCheckpointFile)
+			if err != nil {
+				fatal("failed to read checkpoint", "error", err)
 			}
-			batch = append(batch, objectVersion{
-				Key:       key,
-				VersionId: versionId,
-			})
-			numObjects++
-			if len(batch) == batchSize {
-				waitGroup.Add(1)
-				go deleteObjectVersions(results, s3Client, *fBucket, batch)
-				batch = make([]objectVersion, 0, batchSize)
+			if cp.Bucket != *fBucket {
+				fatal("checkpoint is for a different bucket", "bucket", cp.Bucket)
+			}
+			opts.Resume = cp
+		}
+		opts.OnCheckpoint = func(cp rmdir.Checkpoint) {
+			writeCheckpoint(*fCheckpointFile, cp)
+		}
+	}
+	if *fInputFile != "" {
+		if *fPrecount {
+			fatal("-precount cannot be combined with -input-file")
+		}
+		if *fInputFile == "-" {
+			opts.Input = os.Stdin
+		} else {
+			f, err := os.Open(*fInputFile)
+			if err != nil {
+				fatal("failed to open input file", "error", err)
+			}
+			defer f.Close()
+			opts.Input = f
+		}
+	}
+	if *fFromPlan != "" {
+		if *fPrecount {
+			fatal("-precount cannot be combined with -from-plan")
+		}
+		f, plan := openPlan(*fFromPlan, *fBucket)
+		defer f.Close()
+		opts.FromPlan = plan
+	}
+	// the failures are kept to count them again for the confirmation
+	var retryInput []byte
+	var retried int
+	if *fRetryFailedFromLog != "" {
+		failures, err := readErrorLog(*fRetryFailedFromLog)
+		if err != nil {
+			fatal("failed to read error log", "error", err)
+		}
+		retried = len(failures)
+		retryInput = failureInput(failures)
+		opts.Input = bytes.NewReader(retryInput)
+	}
+	if *fDiffPlan != "" {
+		if err := diffPlan(ctx, s3Client, opts, *fDiffPlan); err != nil {
+			fatal("failed to compare with plan", "error", err)
+		}
+		return
+	}
+	if *fCount {
+		count, err := rmdir.Tally(ctx, s3Client, opts)
+		if err != nil {
+			fatal("failed to count objects", "error", err)
+		}
+		printCount(format, count)
+		return
+	}
+	if !*fQuiet {
+		printSettings(opts, s3Client.Options().Region, *fEndpoint)
+	}
+	if *fKey != "" && !*fForce && !*fDryRun {
+		fmt.Fprintf(os.Stderr, "about to delete version %s of %s from bucket %s\n", *fVersionId, *fKey, *fBucket)
+		if !askBucketName(*fBucket) {
+			fmt.Fprintln(os.Stderr, "aborted")
+			return
+		}
+		start = time.Now()
+	} else if !*fForce && !*fDryRun {
+		countOpts := opts
+		if *fInputFile != "" {
+			// count from a second reader, opts.Input is consumed by the run
+			f, err := os.Open(*fInputFile)
+			if err != nil {
+				fatal("failed to open input file", "error", err)
 			}
+			defer f.Close()
+			countOpts.Input = f
+		}
+		if *fFromPlan != "" {
+			f, plan := openPlan(*fFromPlan, *fBucket)
+			defer f.Close()
+			countOpts.FromPlan = plan
+		}
+		if retryInput != nil {
+			countOpts.Input = bytes.NewReader(retryInput)
+		}
+		ok, err := confirm(ctx, s3Client, countOpts, *fDeleteBucket)
+		if err != nil {
+			fatal("failed to count objects", "error", err)
+		}
+		if !ok {
+			fmt.Fprintln(os.Stderr, "aborted")
+			return
 		}
-		for _, v := range page.Versions {
-			deleteVersion(*v.Key, *v.VersionId)
+		start = time.Now()
+	}
+	runCtx := ctx
+	if *fTimeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, *fTimeout)
+		defer cancel()
+	}
+	var auditLog *bufio.Writer
+	if *fAuditLog != "" && !*fDryRun {
+		f, err := os.OpenFile(*fAuditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			fatal("failed to open audit log", "error", err)
 		}
-		for _, v := range page.DeleteMarkers {
-			deleteVersion(*v.Key, *v.VersionId)
+		defer f.Close()
+		auditLog = bufio.NewWriter(f)
+		opts.AuditLog = auditLog
+	}
+	var plan *bufio.Writer
+	if *fPlanFile != "" {
+		f, err := os.Create(*fPlanFile)
+		if err != nil {
+			fatal("failed to create plan file", "error", err)
 		}
+		defer f.Close()
+		plan = bufio.NewWriter(f)
+		opts.PlanOutput = rmdir.NewPlanWriter(plan, *fBucket)
 	}
-	if len(batch) > 0 {
-		waitGroup.Add(1)
-		go deleteObjectVersions(results, s3Client, *fBucket, batch)
+	// the metrics also back the status written on SIGUSR1
+	opts.Metrics = &rmdir.Metrics{}
+	stopStatus := notifyStatus(opts.Metrics, start)
+	stopMetrics := func() {}
+	if *fMetricsAddr != "" {
+		if stopMetrics, err = serveMetrics(*fMetricsAddr, opts.Metrics); err != nil {
+			fatal("failed to serve metrics", "error", err)
+		}
+	}
+	var summary rmdir.Summary
+	if *fKey != "" {
+		summary, err = rmdir.DeleteVersion(runCtx, s3Client, opts, *fKey, *fVersionId)
+	} else {
+		summary, err = rmdir.DeleteVersions(runCtx, s3Client, opts)
+	}
+	stopMetrics()
+	stopStatus()
+	if auditLog != nil {
+		if err := auditLog.Flush(); err != nil {
+			slog.Error("failed to write audit log", "error", err)
+		}
+	}
+	if plan != nil {
+		if err := opts.PlanOutput.Close(); err != nil {
+			slog.Error("failed to write plan", "error", err)
+		} else if err := plan.Flush(); err != nil {
+			slog.Error("failed to write plan", "error", err)
+		}
+	}
+	if *fErrorLog != "" {
+		if err := writeErrorLog(*fErrorLog, summary.Failures); err != nil {
+			slog.Error("failed to write error log", "error", err)
+		}
+	}
+	elapsed := time.Since(start)
+	status := runStatus(err, summary)
+	if *fSummaryFile != "" {
+		if err := writeSummaryFile(*fSummaryFile, opts, summary, elapsed, status); err != nil {
+			slog.Error("failed to write summary file", "error", err)
+		}
+	}
+	if *fWebhookURL != "" {
+		notifyWebhook(*fWebhookURL, webhookPayload{
+			Bucket:          *fBucket,
+			Prefixes:        opts.Prefixes,
+			Deleted:         summary.Objects - summary.Errors,
+			Errors:          summary.Errors,
+			DurationSeconds: elapsed.Seconds(),
+			DryRun:          *fDryRun,
+			Status:          status,
+			Success:         status == "succeeded",
+		})
+	}
+	report := func(interrupted bool) {
+		printSummary(format, summary, elapsed, *fDryRun, interrupted)
+		if *fStats {
+			printStats(format, summary, opts.Metrics.Snapshot(), elapsed)
+		}
+	}
+	if errors.Is(err, context.Canceled) {
+		report(true)
+		os.Exit(exitInterrupted)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("timeout exceeded", "timeout", *fTimeout)
+		report(true)
+		os.Exit(exitTimeout)
+	}
+	if err != nil {
+		fatal("run failed", "error", err)
+	}
+	report(false)
+	if *fRetryFailedFromLog != "" && format == rmdir.FormatText && !*fDryRun {
+		fmt.Printf("%d of %d previously failed object versions deleted\n", summary.Objects-summary.Errors, retried)
+	}
+	if summary.Locked > 0 {
+		if *fBypassGovernance {
+			slog.Warn("objects are locked in compliance mode or under legal hold and cannot be deleted", "objects", summary.Locked)
+		} else {
+			slog.Warn("objects are protected by object lock; use -bypass-governance for governance mode locks (compliance mode locks and legal holds cannot be bypassed)", "objects", summary.Locked)
+		}
+	}
+	if summary.Errors > 0 || len(summary.ListFailures) > 0 {
+		os.Exit(exitDeleteErrors)
+	}
+	if *fDeleteBucket && !summary.Capped {
+		if !*fDryRun {
+			if err := rmdir.DeleteBucket(ctx, s3Client, opts); err != nil {
+				fatal("failed to delete bucket", "error", err)
+			}
+		}
+		printBucketDeleted(format, *fBucket, *fDryRun)
 	}
-	waitGroup.Wait()
-	fmt.Printf("total number of objects: %d", numObjects)
 }
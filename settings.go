@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/1001R/s3rmdir/rmdir"
+)
+
+type settingsRecord struct {
+	Bucket    string   `json:"bucket"`
+	Prefixes  []string `json:"prefixes"`
+	Region    string   `json:"region"`
+	Endpoint  string   `json:"endpoint,omitempty"`
+	BatchSize int      `json:"batch_size"`
+	// Requests is the number of DeleteObjects requests per batch.
+	Requests    int               `json:"requests_per_batch"`
+	Concurrency int               `json:"concurrency"`
+	Filters     map[string]string `json:"filters,omitempty"`
+	DryRun      bool              `json:"dry_run"`
+}
+
+// printSettings writes the effective settings of a run of opts before it
+// starts, to opts.Output or stdout. An empty endpoint is the AWS endpoint of
+// region.
+func printSettings(opts rmdir.Options, region, endpoint string) {
+	var w io.Writer = os.Stdout
+	if opts.Output != nil {
+		w = opts.Output
+	}
+	s := settingsRecord{
+		Bucket:      opts.Bucket,
+		Prefixes:    opts.Prefixes,
+		Region:      region,
+		Endpoint:    endpoint,
+		BatchSize:   opts.BatchSize,
+		Requests:    (opts.BatchSize + rmdir.MaxDeleteKeys - 1) / rmdir.MaxDeleteKeys,
+		Concurrency: opts.Concurrency,
+		Filters:     activeFilters(),
+		DryRun:      opts.DryRun,
+	}
+	if opts.Format == rmdir.FormatJSON {
+		json.NewEncoder(w).Encode(struct {
+			Settings settingsRecord `json:"settings"`
+		}{s})
+		return
+	}
+	prefixes := strings.Join(s.Prefixes, ", ")
+	if len(s.Prefixes) == 0 || slices.Contains(s.Prefixes, "") {
+		prefixes = "(entire bucket)"
+	}
+	if s.Endpoint == "" {
+		s.Endpoint = "AWS"
+	}
+	filters := make([]string, 0, len(s.Filters))
+	for name, value := range s.Filters {
+		filters = append(filters, fmt.Sprintf("-%s=%s", name, value))
+	}
+	sort.Strings(filters)
+	if len(filters) == 0 {
+		filters = append(filters, "none")
+	}
+	fmt.Fprintf(w, "bucket:      %s\n", s.Bucket)
+	fmt.Fprintf(w, "prefix:      %s\n", prefixes)
+	fmt.Fprintf(w, "region:      %s\n", s.Region)
+	fmt.Fprintf(w, "endpoint:    %s\n", s.Endpoint)
+	if s.Requests > 1 {
+		fmt.Fprintf(w, "batch size:  %d (%d requests of up to %d objects)\n", s.BatchSize, s.Requests, rmdir.MaxDeleteKeys)
+	} else {
+		fmt.Fprintf(w, "batch size:  %d\n", s.BatchSize)
+	}
+	fmt.Fprintf(w, "concurrency: %d\n", s.Concurrency)
+	fmt.Fprintf(w, "filters:     %s\n", strings.Join(filters, " "))
+	fmt.Fprintf(w, "dry run:     %t\n", s.DryRun)
+}
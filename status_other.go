@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"time"
+
+	"github.com/1001R/s3rmdir/rmdir"
+)
+
+// notifyStatus does nothing, as there is no SIGUSR1.
+func notifyStatus(m *rmdir.Metrics, start time.Time) func() {
+	return func() {}
+}
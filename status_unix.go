@@ -0,0 +1,38 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/1001R/s3rmdir/rmdir"
+)
+
+// notifyStatus writes the totals of m to stderr whenever the process receives
+// SIGUSR1, until the returned function is called.
+func notifyStatus(m *rmdir.Metrics, start time.Time) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				s := m.Snapshot()
+				elapsed := time.Since(start)
+				fmt.Fprintf(os.Stderr, "status: %d objects deleted, %d errors, %d batches in flight, %s elapsed, %.0f objects/s\n",
+					s.Deleted, s.Errors, s.InFlight, elapsed.Round(time.Second), float64(s.Deleted)/elapsed.Seconds())
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
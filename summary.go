@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/1001R/s3rmdir/rmdir"
+)
+
+type summaryRecord struct {
+	Objects        int                            `json:"objects"`
+	Errors         int                            `json:"errors"`
+	Retries        int                            `json:"retries"`
+	Skipped        map[string]int                 `json:"skipped,omitempty"`
+	Failures       []rmdir.Failure                `json:"failures,omitempty"`
+	ErrorCodes     map[string]int                 `json:"error_codes,omitempty"`
+	ElapsedSeconds float64                        `json:"elapsed_seconds"`
+	DryRun         bool                           `json:"dry_run"`
+	Interrupted    bool                           `json:"interrupted"`
+	Capped         bool                           `json:"capped"`
+	Prefixes       map[string]rmdir.PrefixSummary `json:"prefixes,omitempty"`
+	Folders        map[string]rmdir.PrefixSummary `json:"folders,omitempty"`
+	Bytes          int64                          `json:"bytes"`
+	ListFailures   []rmdir.ListFailure            `json:"list_failures,omitempty"`
+}
+
+// printSummary writes the final totals of a run to stdout.
+func printSummary(format rmdir.Format, summary rmdir.Summary, elapsed time.Duration, dryRun, interrupted bool) {
+	if format == rmdir.FormatJSON {
+		json.NewEncoder(os.Stdout).Encode(summaryRecord{
+			Objects:        summary.Objects,
+			Errors:         summary.Errors,
+			Retries:        summary.Retries,
+			Skipped:        summary.Skipped,
+			Failures:       summary.Failures,
+			ErrorCodes:     summary.ErrorCodes,
+			ElapsedSeconds: elapsed.Seconds(),
+			DryRun:         dryRun,
+			Interrupted:    interrupted,
+			Capped:         summary.Capped,
+			Prefixes:       summary.Prefixes,
+			Folders:        summary.Folders,
+			Bytes:          summary.Bytes,
+			ListFailures:   summary.ListFailures,
+		})
+		return
+	}
+	printPrefixes(summary.Prefixes)
+	printFolders(summary.Folders)
+	printSkipped(summary.Skipped)
+	printFailures(summary.Failures)
+	printErrorCodes(summary.ErrorCodes)
+	printListFailures(summary.ListFailures)
+	if summary.Capped {
+		fmt.Printf("stopped at the limit of %d objects\n", summary.Objects)
+	}
+	if interrupted {
+		if dryRun {
+			fmt.Printf("%s: would delete %d objects, freeing %s\n", colorize(colorRed, "interrupted"), summary.Objects, formatSize(summary.Bytes))
+		} else {
+			fmt.Printf("%s: %s objects deleted, %s errors, %s freed\n", colorize(colorRed, "interrupted"),
+				colorize(colorBold, fmt.Sprint(summary.Objects)), errorCount(summary.Errors), formatSize(summary.Bytes))
+		}
+		return
+	}
+	if dryRun {
+		fmt.Printf("would delete %d objects, freeing %s\n", summary.Objects, formatSize(summary.Bytes))
+		return
+	}
+	if summary.Retries > 0 {
+		fmt.Printf("%d delete request retries\n", summary.Retries)
+	}
+	if summary.Errors > 0 {
+		fmt.Printf("total number of errors: %s\n", errorCount(summary.Errors))
+	}
+	total := colorGreen
+	if summary.Errors > 0 || len(summary.ListFailures) > 0 {
+		total = colorRed
+	}
+	fmt.Printf("total number of objects: %s\n", colorize(colorBold+total, fmt.Sprint(summary.Objects)))
+	fmt.Printf("total size freed: %s\n", formatSize(summary.Bytes))
+}
+
+type statsRecord struct {
+	ElapsedSeconds      float64 `json:"elapsed_seconds"`
+	ObjectsPerSecond    float64 `json:"objects_per_second"`
+	PeakBatchesInFlight int64   `json:"peak_batches_in_flight"`
+	DeleteRequests      int64   `json:"delete_requests"`
+	Retries             int64   `json:"retries"`
+	Batches             int64   `json:"batches"`
+	AvgBatchSeconds     float64 `json:"avg_batch_seconds"`
+	ThrottledRequests   int64   `json:"throttled_requests"`
+	ConcurrencyLimit    int64   `json:"concurrency_limit"`
+}
+
+// printStats writes the throughput figures of a run to stdout.
+func printStats(format rmdir.Format, summary rmdir.Summary, s rmdir.MetricsSnapshot, elapsed time.Duration) {
+	var avgBatch time.Duration
+	if s.Batches > 0 {
+		avgBatch = s.BatchTime / time.Duration(s.Batches)
+	}
+	rate := float64(summary.Objects) / elapsed.Seconds()
+	if format == rmdir.FormatJSON {
+		json.NewEncoder(os.Stdout).Encode(struct {
+			Stats statsRecord `json:"stats"`
+		}{statsRecord{
+			ElapsedSeconds:      elapsed.Seconds(),
+			ObjectsPerSecond:    rate,
+			PeakBatchesInFlight: s.PeakInFlight,
+			DeleteRequests:      s.Requests,
+			Retries:             s.Retries,
+			Batches:             s.Batches,
+			AvgBatchSeconds:     avgBatch.Seconds(),
+			ThrottledRequests:   s.Throttled,
+			ConcurrencyLimit:    s.ConcurrencyLimit,
+		}})
+		return
+	}
+	fmt.Printf("elapsed:                %s\n", elapsed.Round(time.Millisecond))
+	fmt.Printf("objects per second:     %.1f\n", rate)
+	fmt.Printf("peak batches in flight: %d\n", s.PeakInFlight)
+	fmt.Printf("DeleteObjects requests: %d\n", s.Requests)
+	fmt.Printf("retries:                %d\n", s.Retries)
+	fmt.Printf("average batch latency:  %s over %d batches\n", avgBatch.Round(time.Millisecond), s.Batches)
+	fmt.Printf("throttled requests:     %d\n", s.Throttled)
+	fmt.Printf("concurrency limit:      %d\n", s.ConcurrencyLimit)
+}
+
+// errorCount formats n, in red unless it is zero.
+func errorCount(n int) string {
+	if n == 0 {
+		return "0"
+	}
+	return colorize(colorRed, fmt.Sprint(n))
+}
+
+// formatSize formats a number of bytes with a binary unit, e.g. 12.4 GiB.
+func formatSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	size := float64(n)
+	units := []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	unit := ""
+	for _, unit = range units {
+		size /= 1024
+		if size < 1024 {
+			break
+		}
+	}
+	return fmt.Sprintf("%.1f %s", size, unit)
+}
+
+// printCount writes the totals of a count-only run to stdout.
+func printCount(format rmdir.Format, count rmdir.Count) {
+	if format == rmdir.FormatJSON {
+		json.NewEncoder(os.Stdout).Encode(count)
+		return
+	}
+	printListFailures(count.ListFailures)
+	if !count.Sampled {
+		fmt.Printf("%d keys, %d versions (%d delete markers), %s\n", count.Keys, count.Versions, count.DeleteMarkers, formatSize(count.Bytes))
+		return
+	}
+	fmt.Printf("sample of %d listed entries: %d keys, %d versions (%d delete markers), %s\n",
+		count.Listed, count.Keys, count.Versions, count.DeleteMarkers, formatSize(count.Bytes))
+	if count.Listed > 0 && count.Versions > 0 {
+		fmt.Printf("in the sample: %.1f%% of the listed entries would be deleted, %s per version on average\n",
+			100*float64(count.Versions)/float64(count.Listed), formatSize(count.Bytes/int64(count.Versions)))
+	}
+	if e := count.Estimated; e != nil {
+		fmt.Printf("estimated: %d keys, %d versions (%d delete markers), %s, with %.1f%% of all entries listed\n",
+			e.Keys, e.Versions, e.DeleteMarkers, formatSize(e.Bytes), 100*e.Share)
+	} else {
+		fmt.Println("estimated: unknown, the share of the keys listed cannot be told")
+	}
+}
+
+type bucketRecord struct {
+	Bucket string `json:"bucket"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// printBucketDeleted writes that bucket was deleted, or would be deleted in
+// a dry run.
+func printBucketDeleted(format rmdir.Format, bucket string, dryRun bool) {
+	switch {
+	case format == rmdir.FormatJSON:
+		json.NewEncoder(os.Stdout).Encode(struct {
+			DeletedBucket bucketRecord `json:"deleted_bucket"`
+		}{bucketRecord{Bucket: bucket, DryRun: dryRun}})
+	case dryRun:
+		fmt.Printf("would delete bucket %s\n", bucket)
+	default:
+		fmt.Printf("deleted bucket %s\n", bucket)
+	}
+}
+
+// printSkipped writes the number of objects rejected by each filter.
+func printSkipped(skipped map[string]int) {
+	filters := make([]string, 0, len(skipped))
+	for f := range skipped {
+		filters = append(filters, f)
+	}
+	sort.Strings(filters)
+	for _, f := range filters {
+		fmt.Printf("%d objects skipped by %s filter\n", skipped[f], f)
+	}
+}
+
+// printErrorCodes writes the number of errors by S3 error code, most frequent
+// first.
+func printErrorCodes(codes map[string]int) {
+	if len(codes) == 0 {
+		return
+	}
+	sorted := make([]string, 0, len(codes))
+	for c := range codes {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if codes[sorted[i]] != codes[sorted[j]] {
+			return codes[sorted[i]] > codes[sorted[j]]
+		}
+		return sorted[i] < sorted[j]
+	})
+	fmt.Println("errors by code:")
+	for _, c := range sorted {
+		fmt.Printf("  %-24s %d\n", c, codes[c])
+	}
+}
+
+// printFailures lists the object versions that could not be deleted.
+func printFailures(failures []rmdir.Failure) {
+	if len(failures) == 0 {
+		return
+	}
+	fmt.Printf("%s %d objects:\n", colorize(colorRed, "failed to delete"), len(failures))
+	for _, f := range failures {
+		fmt.Printf("  %s (version %s): %s\n", rmdir.QuoteKey(f.Key), f.VersionId, f.Code)
+	}
+}
+
+// printListFailures lists the key ranges that could not be listed.
+func printListFailures(failures []rmdir.ListFailure) {
+	for _, f := range failures {
+		until := "the end"
+		if f.Until != "" {
+			until = fmt.Sprintf("%q", f.Until)
+		}
+		fmt.Printf("failed to list prefix %q after %q up to %s: %s\n", f.Prefix, f.After, until, f.Message)
+	}
+}
+
+// printFolders writes a table of the totals of every folder.
+func printFolders(folders map[string]rmdir.PrefixSummary) {
+	if len(folders) == 0 {
+		return
+	}
+	names := make([]string, 0, len(folders))
+	for f := range folders {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "folder\tobjects\terrors\tsize")
+	for _, f := range names {
+		s := folders[f]
+		fmt.Fprintf(w, "%q\t%d\t%d\t%s\n", f, s.Objects, s.Errors, formatSize(s.Bytes))
+	}
+	w.Flush()
+}
+
+// printPrefixes writes a table of the totals per prefix if there is more than
+// one prefix.
+func printPrefixes(prefixes map[string]rmdir.PrefixSummary) {
+	if len(prefixes) < 2 {
+		return
+	}
+	names := make([]string, 0, len(prefixes))
+	for p := range prefixes {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "prefix\tobjects\terrors\tsize")
+	for _, p := range names {
+		s := prefixes[p]
+		fmt.Fprintf(w, "%q\t%d\t%d\t%s\n", p, s.Objects, s.Errors, formatSize(s.Bytes))
+	}
+	w.Flush()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/1001R/s3rmdir/rmdir"
+)
+
+// filterFlags are the flags selecting which object versions are deleted,
+// reported as the filters of a run.
+var filterFlags = []string{
+	"older-than", "newer-than", "noncurrent-older-than", "min-age", "exclude-newer-than-start",
+	"min-size", "max-size", "size-include-markers", "suffix", "include", "exclude", "exclude-prefix",
+	"glob", "storage-class", "tag", "markers-only", "keep", "latest-only", "non-recursive",
+	"skip-locked", "max-objects", "owner", "empty-folders", "no-delete-markers",
+}
+
+// summaryFile is the JSON report written with -summary-file.
+type summaryFile struct {
+	Bucket   string   `json:"bucket"`
+	Prefixes []string `json:"prefixes"`
+	// Filters maps the filter flags given on the command line to their
+	// values.
+	Filters         map[string]string `json:"filters,omitempty"`
+	Objects         int               `json:"objects"`
+	Bytes           int64             `json:"bytes"`
+	Errors          int               `json:"errors"`
+	ErrorCodes      map[string]int    `json:"error_codes,omitempty"`
+	Skipped         map[string]int    `json:"skipped,omitempty"`
+	ListFailures    int               `json:"list_failures"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	DryRun          bool              `json:"dry_run"`
+	// Status is one of succeeded, completed with errors, canceled, timed
+	// out and failed, see runStatus.
+	Status string `json:"status"`
+}
+
+// activeFilters returns the filter flags that were set and their values.
+func activeFilters() map[string]string {
+	filters := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		for _, name := range filterFlags {
+			if f.Name == name {
+				filters[name] = f.Value.String()
+			}
+		}
+	})
+	return filters
+}
+
+// writeSummaryFile writes the report of a run of opts as JSON to the file at
+// path. The summary of an interrupted or failed run reflects what was done up
+// to that point.
+func writeSummaryFile(path string, opts rmdir.Options, summary rmdir.Summary, elapsed time.Duration, status string) error {
+	report := summaryFile{
+		Bucket:          opts.Bucket,
+		Prefixes:        opts.Prefixes,
+		Filters:         activeFilters(),
+		Objects:         summary.Objects,
+		Bytes:           summary.Bytes,
+		Errors:          summary.Errors,
+		ErrorCodes:      summary.ErrorCodes,
+		Skipped:         summary.Skipped,
+		ListFailures:    len(summary.ListFailures),
+		DurationSeconds: elapsed.Seconds(),
+		DryRun:          opts.DryRun,
+		Status:          status,
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/1001R/s3rmdir/rmdir"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"gopkg.in/yaml.v3"
+)
+
+// target is a bucket, prefix and region to delete from, read from -config.
+type target struct {
+	Bucket string `yaml:"bucket"`
+	Prefix string `yaml:"prefix"`
+	// Region overrides the region of the SDK config, unless it is empty;
+	// -auto-region fills in the region of the bucket.
+	Region string `yaml:"region"`
+}
+
+// targetsConfig is the file given with -config, e.g.
+//
+//	targets:
+//	  - bucket: logs-eu
+//	    prefix: tmp/
+//	    region: eu-west-1
+type targetsConfig struct {
+	Targets []target `yaml:"targets"`
+}
+
+// readTargets reads and validates the targets of the config file at path.
+// An empty prefix requires all, like the empty -prefix.
+func readTargets(path string, all bool) ([]target, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var c targetsConfig
+	if err := decoder.Decode(&c); err != nil {
+		return nil, err
+	}
+	if len(c.Targets) == 0 {
+		return nil, errors.New("no targets")
+	}
+	seen := make(map[target]bool)
+	for i, t := range c.Targets {
+		if t.Bucket == "" {
+			return nil, fmt.Errorf("target %d: no bucket", i+1)
+		}
+		if ok, err := isBucketARN(t.Bucket); err != nil || ok {
+			return nil, fmt.Errorf("target %d: access point ARNs are not supported", i+1)
+		}
+		t.Prefix = folderPrefix(t.Prefix)
+		if t.Prefix == "" && !all {
+			return nil, fmt.Errorf("target %d: no prefix given for bucket %s, which would delete every object version; pass -all to proceed", i+1, t.Bucket)
+		}
+		key := target{Bucket: t.Bucket, Prefix: t.Prefix}
+		if seen[key] {
+			return nil, fmt.Errorf("target %d: duplicate target %s/%s", i+1, t.Bucket, t.Prefix)
+		}
+		seen[key] = true
+		c.Targets[i] = t
+	}
+	return c.Targets, nil
+}
+
+// matchBuckets returns a target for every prefix of every bucket of the
+// account whose name matches pattern, in the region of the bucket.
+func matchBuckets(ctx context.Context, client *s3.Client, pattern *regexp.Regexp, prefixes []string) ([]target, error) {
+	out, err := client.ListBuckets(ctx, &s3.ListBucketsInput{})
+	if err != nil {
+		return nil, err
+	}
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+	var targets []target
+	for _, b := range out.Buckets {
+		name := aws.ToString(b.Name)
+		if !pattern.MatchString(name) {
+			continue
+		}
+		region, err := bucketRegion(ctx, client, name)
+		if err != nil {
+			return nil, fmt.Errorf("region of bucket %s: %w", name, err)
+		}
+		for _, p := range prefixes {
+			targets = append(targets, target{Bucket: name, Prefix: p, Region: region})
+		}
+	}
+	return targets, nil
+}
+
+// detectRegions sets the region of the targets without one to the region of
+// their bucket. A target whose region cannot be detected keeps the region of
+// the SDK config.
+func detectRegions(ctx context.Context, client *s3.Client, targets []target) {
+	regions := make(map[string]string)
+	for i, t := range targets {
+		if t.Region != "" {
+			continue
+		}
+		region, ok := regions[t.Bucket]
+		if !ok {
+			var err error
+			if region, err = bucketRegion(ctx, client, t.Bucket); err != nil {
+				slog.Warn("failed to detect the region of the bucket", "bucket", t.Bucket, "error", err)
+			}
+			regions[t.Bucket] = region
+		}
+		targets[i].Region = region
+	}
+}
+
+// targetResult is the outcome of the run of a target.
+type targetResult struct {
+	target  target
+	summary rmdir.Summary
+	err     error
+}
+
+// runTargets deletes from all targets concurrently with the options of opts.
+// Their workers share concurrency slots, so that no more than concurrency
+// batches are deleted at a time overall. It prints the summary of every
+// target and the overall totals, notifies webhookURL of them unless it is
+// empty and returns the exit code.
+func runTargets(ctx context.Context, cfg aws.Config, clientOptions func(*s3.Options), opts rmdir.Options, targets []target, concurrency int, webhookURL string) int {
+	opts.Slots = make(chan struct{}, concurrency)
+	// the batches of the targets would interleave
+	opts.Quiet = true
+	start := time.Now()
+	results := make([]targetResult, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t target) {
+			defer wg.Done()
+			results[i] = runTarget(ctx, cfg, clientOptions, opts, t)
+		}(i, t)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	total := rmdir.Summary{Skipped: make(map[string]int)}
+	code := 0
+	// err is the first error of a target, or the error of ctx that ended
+	// the run
+	err := ctx.Err()
+	names := make([]string, len(results))
+	for i, r := range results {
+		names[i] = r.target.Bucket + "/" + r.target.Prefix
+		total.Objects += r.summary.Objects
+		total.Errors += r.summary.Errors
+		total.Retries += r.summary.Retries
+		total.Bytes += r.summary.Bytes
+		total.ListFailures = append(total.ListFailures, r.summary.ListFailures...)
+		for filter, n := range r.summary.Skipped {
+			total.Skipped[filter] += n
+		}
+		if r.err != nil || r.summary.Errors > 0 || len(r.summary.ListFailures) > 0 {
+			code = exitDeleteErrors
+		}
+		if err == nil {
+			err = r.err
+		}
+	}
+	canceled := errors.Is(err, context.Canceled)
+	timedOut := errors.Is(err, context.DeadlineExceeded)
+	if timedOut {
+		slog.Warn("timeout exceeded")
+	}
+	printTargets(opts.Format, results)
+	printSummary(opts.Format, total, elapsed, opts.DryRun, canceled || timedOut)
+	if webhookURL != "" {
+		status := runStatus(err, total)
+		notifyWebhook(webhookURL, webhookPayload{
+			Targets:         names,
+			Deleted:         total.Objects - total.Errors,
+			Errors:          total.Errors,
+			DurationSeconds: elapsed.Seconds(),
+			DryRun:          opts.DryRun,
+			Status:          status,
+			Success:         status == "succeeded",
+		})
+	}
+	switch {
+	case canceled:
+		return exitInterrupted
+	case timedOut:
+		return exitTimeout
+	}
+	return code
+}
+
+func runTarget(ctx context.Context, cfg aws.Config, clientOptions func(*s3.Options), opts rmdir.Options, t target) targetResult {
+	client := s3.NewFromConfig(cfg, clientOptions, func(o *s3.Options) {
+		if t.Region != "" {
+			o.Region = t.Region
+		}
+	})
+	if msg, code := checkBucket(ctx, client, t.Bucket); code != 0 {
+		slog.Error(msg)
+		return targetResult{target: t, err: errors.New(msg)}
+	}
+	opts.Bucket = t.Bucket
+	opts.Prefixes = []string{t.Prefix}
+	opts.DirectoryBucket = rmdir.IsDirectoryBucket(t.Bucket)
+	opts.Logger = slog.Default().With("bucket", t.Bucket, "prefix", t.Prefix)
+	summary, err := rmdir.DeleteVersions(ctx, client, opts)
+	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		slog.Error("run failed", "bucket", t.Bucket, "prefix", t.Prefix, "error", err)
+	}
+	return targetResult{target: t, summary: summary, err: err}
+}
+
+type targetRecord struct {
+	Bucket  string `json:"bucket"`
+	Prefix  string `json:"prefix"`
+	Objects int    `json:"objects"`
+	Errors  int    `json:"errors"`
+	Bytes   int64  `json:"bytes"`
+	Error   string `json:"error,omitempty"`
+}
+
+// printTargets writes the totals of every target to stdout.
+func printTargets(format rmdir.Format, results []targetResult) {
+	if format == rmdir.FormatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, r := range results {
+			record := targetRecord{
+				Bucket:  r.target.Bucket,
+				Prefix:  r.target.Prefix,
+				Objects: r.summary.Objects,
+				Errors:  r.summary.Errors,
+				Bytes:   r.summary.Bytes,
+			}
+			if r.err != nil {
+				record.Error = r.err.Error()
+			}
+			encoder.Encode(record)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "bucket\tprefix\tobjects\terrors\tsize")
+	for _, r := range results {
+		errs := fmt.Sprint(r.summary.Errors)
+		if r.err != nil {
+			errs = "failed"
+		}
+		fmt.Fprintf(w, "%s\t%q\t%d\t%s\t%s\n", r.target.Bucket, r.target.Prefix, r.summary.Objects, errs, formatSize(r.summary.Bytes))
+	}
+	w.Flush()
+}
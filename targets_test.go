@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// TestDetectRegions checks that -auto-region fills in the region of the
+// -config targets without one, asking once per bucket.
+func TestDetectRegions(t *testing.T) {
+	var mu sync.Mutex
+	asked := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket := strings.Trim(r.URL.Path, "/")
+		mu.Lock()
+		asked[bucket]++
+		mu.Unlock()
+		if bucket == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchBucket</Code><Message>not found</Message></Error>`)
+			return
+		}
+		fmt.Fprint(w, `<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">eu-central-1</LocationConstraint>`)
+	}))
+	defer server.Close()
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	targets := []target{
+		{Bucket: "logs", Prefix: "a/"},
+		{Bucket: "logs", Prefix: "b/"},
+		{Bucket: "data", Prefix: "a/", Region: "us-west-2"},
+		{Bucket: "missing", Prefix: "a/"},
+	}
+	detectRegions(context.Background(), client, targets)
+	regions := []string{"eu-central-1", "eu-central-1", "us-west-2", ""}
+	for i, want := range regions {
+		if got := targets[i].Region; got != want {
+			t.Errorf("target %d: region %q, want %q", i+1, got, want)
+		}
+	}
+	if asked["logs"] != 1 || asked["data"] != 0 {
+		t.Errorf("asked for the regions %v, want logs once and not data", asked)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// version and commit can be set at build time with
+// -ldflags "-X main.version=... -X main.commit=...". Otherwise they are taken
+// from the build info.
+var (
+	version = ""
+	commit  = ""
+)
+
+// versionString describes the build.
+func versionString() string {
+	v, c := version, commit
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" {
+			v = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" && c == "" {
+				c = s.Value
+			}
+		}
+	}
+	if v == "" {
+		v = "(devel)"
+	}
+	if c == "" {
+		c = "unknown"
+	}
+	return fmt.Sprintf("s3rmdir %s (commit %s, %s)", v, c, runtime.Version())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/1001R/s3rmdir/rmdir"
+)
+
+// webhookTimeout bounds the notification request, so that an unreachable
+// webhook does not hold up the end of the run.
+const webhookTimeout = 10 * time.Second
+
+type webhookPayload struct {
+	Bucket   string   `json:"bucket,omitempty"`
+	Prefixes []string `json:"prefixes"`
+	// Targets lists the bucket/prefix of every target of -config and
+	// -bucket-pattern, which have no single Bucket and Prefixes.
+	Targets         []string `json:"targets,omitempty"`
+	Deleted         int      `json:"deleted"`
+	Errors          int      `json:"errors"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	DryRun          bool     `json:"dry_run"`
+	// Status is one of succeeded, completed with errors, canceled, timed
+	// out and failed.
+	Status  string `json:"status"`
+	Success bool   `json:"success"`
+}
+
+// runStatus describes the outcome of a run that ended with err.
+func runStatus(err error, summary rmdir.Summary) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timed out"
+	case err != nil:
+		return "failed"
+	case summary.Errors > 0 || len(summary.ListFailures) > 0:
+		return "completed with errors"
+	}
+	return "succeeded"
+}
+
+// notifyWebhook posts payload to url. It only logs failures; the
+// notification never affects the outcome of the run.
+func notifyWebhook(url string, payload webhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("failed to encode webhook payload", "error", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("failed to notify webhook", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+	}
+	if err != nil {
+		slog.Warn("failed to notify webhook", "error", err)
+	}
+}